@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"math/big"
+	"time"
+)
+
+// precisionExact marks tasks whose arguments and result are rational
+// strings such as "3/10"
+const precisionExact = "exact"
+
+// max_exact_bits bounds the size of a power, so a task like 10^10^9
+// fails instead of exhausting memory
+const max_exact_bits = 1 << 20
+
+// ErrInexact is reported for operations without an exact rational
+// result, e.g. sqrt or a fractional power
+var ErrInexact = errors.New("inexact_operation")
+
+func computeExactTask(task *Task) (string, error) {
+	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+
+	arg1, ok := new(big.Rat).SetString(task.ExactArg1)
+	if !ok {
+		return "", ErrNotANumber
+	}
+	// Unary operations come without the second argument
+	arg2, ok := new(big.Rat).SetString(task.ExactArg2)
+	if !ok {
+		arg2 = new(big.Rat)
+	}
+
+	result := new(big.Rat)
+	switch task.Operation {
+	case "+":
+		result.Add(arg1, arg2)
+	case "-":
+		result.Sub(arg1, arg2)
+	case "*":
+		result.Mul(arg1, arg2)
+	case "/":
+		if arg2.Sign() == 0 {
+			return "", ErrDivisionByZero
+		}
+		result.Quo(arg1, arg2)
+	case "%":
+		if arg2.Sign() == 0 {
+			return "", ErrDivisionByZero
+		}
+		// Truncated like math.Mod, the sign follows the dividend
+		quotient := new(big.Rat).SetInt(truncate(new(big.Rat).Quo(arg1, arg2)))
+		result.Sub(arg1, quotient.Mul(quotient, arg2))
+	case "^":
+		power, err := exactPower(arg1, arg2)
+		if err != nil {
+			return "", err
+		}
+		result = power
+	case "neg":
+		result.Neg(arg1)
+	case "abs":
+		result.Abs(arg1)
+	case "floor":
+		result.SetInt(floor(arg1))
+	case "ceil":
+		result.SetInt(floor(new(big.Rat).Neg(arg1)))
+		result.Neg(result)
+	case "round":
+		// Half away from zero like math.Round
+		half := new(big.Rat).Add(new(big.Rat).Abs(arg1), big.NewRat(1, 2))
+		result.SetInt(floor(half))
+		if arg1.Sign() < 0 {
+			result.Neg(result)
+		}
+	case "min":
+		result.Set(arg1)
+		if arg2.Cmp(arg1) < 0 {
+			result.Set(arg2)
+		}
+	case "max":
+		result.Set(arg1)
+		if arg2.Cmp(arg1) > 0 {
+			result.Set(arg2)
+		}
+	case "==", "!=", "<", "<=", ">", ">=":
+		result.SetFloat64(boolValue(compare(task.Operation, arg1.Cmp(arg2))))
+	case "&&":
+		result.SetFloat64(boolValue(arg1.Sign() != 0 && arg2.Sign() != 0))
+	case "||":
+		result.SetFloat64(boolValue(arg1.Sign() != 0 || arg2.Sign() != 0))
+	case "!":
+		result.SetFloat64(boolValue(arg1.Sign() == 0))
+	case "sqrt", "exp", "ln", "log", "sin", "cos", "tan":
+		return "", ErrInexact
+	default:
+		return "", ErrUnknownOperation
+	}
+
+	return result.RatString(), nil
+}
+
+// compare applies a comparison operator to the result of big.Rat.Cmp
+func compare(operation string, cmp int) bool {
+	switch operation {
+	case "==":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp >= 0
+	}
+}
+
+func floor(value *big.Rat) *big.Int {
+	// Euclidean division by the positive denominator rounds down
+	return new(big.Int).Div(value.Num(), value.Denom())
+}
+
+func truncate(value *big.Rat) *big.Int {
+	return new(big.Int).Quo(value.Num(), value.Denom())
+}
+
+// exactPower raises base to an integer exponent
+func exactPower(base *big.Rat, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() {
+		return nil, ErrInexact
+	}
+	if !exponent.Num().IsInt64() {
+		return nil, ErrOverflow
+	}
+	n := exponent.Num().Int64()
+	if n < 0 && base.Sign() == 0 {
+		return nil, ErrDivisionByZero
+	}
+
+	abs := new(big.Int).Abs(exponent.Num())
+	// Powers of 0, 1 and -1 stay small for any exponent
+	magnitude := int64(max(base.Num().BitLen(), base.Denom().BitLen()) - 1)
+	if magnitude > 0 && (abs.Cmp(big.NewInt(max_exact_bits)) > 0 || magnitude*abs.Int64() > max_exact_bits) {
+		return nil, ErrOverflow
+	}
+
+	num := new(big.Int).Exp(base.Num(), abs, nil)
+	denom := new(big.Int).Exp(base.Denom(), abs, nil)
+	if n < 0 {
+		num, denom = denom, num
+	}
+	return new(big.Rat).SetFrac(num, denom), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestComputeExactTask(t *testing.T) {
+	tests := []struct {
+		name        string
+		task        Task
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:     "Addition without rounding",
+			task:     Task{ExactArg1: "1/10", ExactArg2: "2/10", Operation: "+"},
+			expected: "3/10",
+		},
+		{
+			name:     "Division",
+			task:     Task{ExactArg1: "1", ExactArg2: "3", Operation: "/"},
+			expected: "1/3",
+		},
+		{
+			name:        "Division by zero",
+			task:        Task{ExactArg1: "1", ExactArg2: "0", Operation: "/"},
+			expectedErr: ErrDivisionByZero,
+		},
+		{
+			name:     "Modulo keeps the sign of the dividend",
+			task:     Task{ExactArg1: "-7", ExactArg2: "3/2", Operation: "%"},
+			expected: "-1",
+		},
+		{
+			name:     "Negative power",
+			task:     Task{ExactArg1: "2/3", ExactArg2: "-2", Operation: "^"},
+			expected: "9/4",
+		},
+		{
+			name:        "Fractional power",
+			task:        Task{ExactArg1: "2", ExactArg2: "1/2", Operation: "^"},
+			expectedErr: ErrInexact,
+		},
+		{
+			name:        "Huge power",
+			task:        Task{ExactArg1: "10", ExactArg2: "1000000000", Operation: "^"},
+			expectedErr: ErrOverflow,
+		},
+		{
+			name:     "Huge power of one",
+			task:     Task{ExactArg1: "-1", ExactArg2: "1000000001", Operation: "^"},
+			expected: "-1",
+		},
+		{
+			name:        "Zero to a negative power",
+			task:        Task{ExactArg1: "0", ExactArg2: "-1", Operation: "^"},
+			expectedErr: ErrDivisionByZero,
+		},
+		{
+			name:     "Negation",
+			task:     Task{ExactArg1: "1/10", Operation: "neg"},
+			expected: "-1/10",
+		},
+		{
+			name:     "Floor of a negative number",
+			task:     Task{ExactArg1: "-1/2", Operation: "floor"},
+			expected: "-1",
+		},
+		{
+			name:     "Ceil of a negative number",
+			task:     Task{ExactArg1: "-1/2", Operation: "ceil"},
+			expected: "0",
+		},
+		{
+			name:     "Round half away from zero",
+			task:     Task{ExactArg1: "-5/2", Operation: "round"},
+			expected: "-3",
+		},
+		{
+			name:     "Max",
+			task:     Task{ExactArg1: "1/3", ExactArg2: "3/10", Operation: "max"},
+			expected: "1/3",
+		},
+		{
+			name:     "Exact equality",
+			task:     Task{ExactArg1: "3/10", ExactArg2: "3/10", Operation: "=="},
+			expected: "1",
+		},
+		{
+			name:     "Greater than",
+			task:     Task{ExactArg1: "1/3", ExactArg2: "1/3", Operation: ">"},
+			expected: "0",
+		},
+		{
+			name:     "Boolean or",
+			task:     Task{ExactArg1: "0", ExactArg2: "-1/2", Operation: "||"},
+			expected: "1",
+		},
+		{
+			name:        "Square root",
+			task:        Task{ExactArg1: "4", Operation: "sqrt"},
+			expectedErr: ErrInexact,
+		},
+		{
+			name:        "Malformed argument",
+			task:        Task{ExactArg1: "0.1.2", ExactArg2: "1", Operation: "+"},
+			expectedErr: ErrNotANumber,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.Precision = precisionExact
+			result, err := computeExactTask(&tt.task)
+			if err != tt.expectedErr {
+				t.Fatalf("Expected error %v, got %v", tt.expectedErr, err)
+			}
+			if result != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/Raikh/calc_micro/internal/agentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runGRPC serves one Connect session with computingPower workers and
+// returns when the stream breaks
+func runGRPC(ctx context.Context, computingPower int) error {
+	conn, err := grpc.NewClient(grpc_address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return serveStream(ctx, agentpb.NewAgentServiceClient(conn), computingPower)
+}
+
+func serveStream(ctx context.Context, client agentpb.AgentServiceClient, computingPower int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Send is not safe to call from several goroutines
+	var sendMutex sync.Mutex
+	send := func(msg *agentpb.AgentMessage) {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		if err := stream.Send(msg); err != nil {
+			log.Println("Error sending result:", err)
+		}
+	}
+
+	send(&agentpb.AgentMessage{
+		Message: &agentpb.AgentMessage_Ready{Ready: &agentpb.Ready{Slots: int32(computingPower)}},
+	})
+
+	pending := make(chan *Task)
+	var workers sync.WaitGroup
+	for i := 0; i < computingPower; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for task := range pending {
+				result := &agentpb.Result{Id: task.ID}
+				var err error
+				if task.Precision == precisionExact {
+					result.ExactResult, err = computeExactTask(task)
+				} else {
+					result.Result, err = computeTask(task)
+				}
+				if err != nil {
+					result.Error = err.Error()
+				}
+				send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Result{Result: result}})
+			}
+		}()
+	}
+	defer func() {
+		close(pending)
+		workers.Wait()
+	}()
+
+	for {
+		task, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		pending <- &Task{
+			ID:            task.Id,
+			Arg1:          task.Arg1,
+			Arg2:          task.Arg2,
+			Operation:     task.Operation,
+			OperationTime: int(task.OperationTime),
+			Precision:     task.Precision,
+			ExactArg1:     task.ExactArg1,
+			ExactArg2:     task.ExactArg2,
+		}
+	}
+}
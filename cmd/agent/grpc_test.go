@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Raikh/calc_micro/internal/agentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testOrchestrator pushes one task per Ready slot and collects results
+type testOrchestrator struct {
+	agentpb.UnimplementedAgentServiceServer
+	tasks   []*agentpb.Task
+	results chan *agentpb.Result
+}
+
+func (o *testOrchestrator) Connect(stream agentpb.AgentService_ConnectServer) error {
+	msg, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if ready := msg.GetReady(); ready == nil || ready.Slots < 1 {
+		return nil
+	}
+
+	for _, task := range o.tasks {
+		if err := stream.Send(task); err != nil {
+			return err
+		}
+	}
+	for range o.tasks {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		o.results <- msg.GetResult()
+	}
+	return nil
+}
+
+func TestServeStream(t *testing.T) {
+	orchestrator := &testOrchestrator{
+		tasks: []*agentpb.Task{
+			{Id: "add", Arg1: 10, Arg2: 5, Operation: "+"},
+			{Id: "div", Arg1: 10, Arg2: 0, Operation: "/"},
+		},
+		results: make(chan *agentpb.Result, 2),
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	agentpb.RegisterAgentServiceServer(server, orchestrator)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go serveStream(ctx, agentpb.NewAgentServiceClient(conn), 2)
+
+	results := make(map[string]*agentpb.Result)
+	for range orchestrator.tasks {
+		select {
+		case result := <-orchestrator.results:
+			results[result.Id] = result
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for results")
+		}
+	}
+
+	if results["add"].Result != 15 || results["add"].Error != "" {
+		t.Errorf("Expected add result 15, got %v", results["add"])
+	}
+	if results["div"].Error != "division_by_zero" {
+		t.Errorf("Expected div error division_by_zero, got %v", results["div"])
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
@@ -17,60 +20,184 @@ type Task struct {
 	Arg2          float64 `json:"arg2"`
 	Operation     string  `json:"operation"`
 	OperationTime int     `json:"operation_time"`
+	Precision     string  `json:"precision"`
+	ExactArg1     string  `json:"exact_arg1"`
+	ExactArg2     string  `json:"exact_arg2"`
 }
 
 var (
 	api_base_url string
+	poll_wait    time.Duration
+	protocol     string
+	grpc_address string
 )
 
-func getTask() *Task {
-	resp, err := http.Get(api_base_url + "/internal/task")
+// Failure reasons reported to the orchestrator
+var (
+	ErrDivisionByZero   = errors.New("division_by_zero")
+	ErrOverflow         = errors.New("overflow")
+	ErrNotANumber       = errors.New("not_a_number")
+	ErrUnknownOperation = errors.New("unknown_operation")
+)
+
+// httpClient talks to the orchestrator over HTTP. Its workers use these
+// settings instead of the flags, so each can be configured on its own.
+type httpClient struct {
+	baseURL string
+	// pollWait is how long the orchestrator may hold a task request
+	pollWait time.Duration
+	// emptyPollBackoff is the pause after an empty answer that came
+	// before pollWait elapsed, so a worker does not hammer the orchestrator
+	emptyPollBackoff time.Duration
+}
+
+// getTask asks the orchestrator for a task, waiting up to pollWait
+// for one to become ready. It returns nil without an error when no
+// task showed up in time.
+func (c *httpClient) getTask(ctx context.Context) (*Task, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/internal/task?wait="+c.pollWait.String(), nil)
 	if err != nil {
-		log.Println("Error getting task:", err)
-		return nil
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
 	}
 
 	var taskResponse struct {
 		Task Task `json:"task"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&taskResponse); err != nil {
-		log.Println("Error decoding task:", err)
-		return nil
+		return nil, err
 	}
 
-	return &taskResponse.Task
+	return &taskResponse.Task, nil
 }
 
-func computeTask(task *Task) float64 {
+func computeTask(task *Task) (float64, error) {
 	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
 
+	var result float64
 	switch task.Operation {
 	case "+":
-		return task.Arg1 + task.Arg2
+		result = task.Arg1 + task.Arg2
 	case "-":
-		return task.Arg1 - task.Arg2
+		result = task.Arg1 - task.Arg2
 	case "*":
-		return task.Arg1 * task.Arg2
+		result = task.Arg1 * task.Arg2
 	case "/":
-		return task.Arg1 / task.Arg2
+		if task.Arg2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		result = task.Arg1 / task.Arg2
+	case "%":
+		if task.Arg2 == 0 {
+			return 0, ErrDivisionByZero
+		}
+		result = math.Mod(task.Arg1, task.Arg2)
+	case "^":
+		result = math.Pow(task.Arg1, task.Arg2)
+	case "neg":
+		result = -task.Arg1
+	case "abs":
+		result = math.Abs(task.Arg1)
+	case "sqrt":
+		result = math.Sqrt(task.Arg1)
+	case "exp":
+		result = math.Exp(task.Arg1)
+	case "ln":
+		result = math.Log(task.Arg1)
+	case "log":
+		result = math.Log10(task.Arg1)
+	case "sin":
+		result = math.Sin(task.Arg1)
+	case "cos":
+		result = math.Cos(task.Arg1)
+	case "tan":
+		result = math.Tan(task.Arg1)
+	case "floor":
+		result = math.Floor(task.Arg1)
+	case "ceil":
+		result = math.Ceil(task.Arg1)
+	case "round":
+		result = math.Round(task.Arg1)
+	case "min":
+		result = math.Min(task.Arg1, task.Arg2)
+	case "max":
+		result = math.Max(task.Arg1, task.Arg2)
+	case "==":
+		result = boolValue(task.Arg1 == task.Arg2)
+	case "!=":
+		result = boolValue(task.Arg1 != task.Arg2)
+	case "<":
+		result = boolValue(task.Arg1 < task.Arg2)
+	case "<=":
+		result = boolValue(task.Arg1 <= task.Arg2)
+	case ">":
+		result = boolValue(task.Arg1 > task.Arg2)
+	case ">=":
+		result = boolValue(task.Arg1 >= task.Arg2)
+	case "&&":
+		result = boolValue(task.Arg1 != 0 && task.Arg2 != 0)
+	case "||":
+		result = boolValue(task.Arg1 != 0 || task.Arg2 != 0)
+	case "!":
+		result = boolValue(task.Arg1 == 0)
 	default:
-		return 0
+		return 0, ErrUnknownOperation
+	}
+
+	if math.IsNaN(result) {
+		return 0, ErrNotANumber
+	}
+	if math.IsInf(result, 0) {
+		return 0, ErrOverflow
+	}
+	return result, nil
+}
+
+// boolValue is the result of comparison and boolean operations
+func boolValue(value bool) float64 {
+	if value {
+		return 1
 	}
+	return 0
 }
 
-func sendResult(taskID string, result float64) {
-	reqBody, _ := json.Marshal(map[string]interface{}{
+func (c *httpClient) sendResult(taskID string, result float64) {
+	c.postResult(map[string]interface{}{
 		"id":     taskID,
 		"result": result,
 	})
+}
+
+func (c *httpClient) sendExactResult(taskID string, result string) {
+	c.postResult(map[string]interface{}{
+		"id":           taskID,
+		"exact_result": result,
+	})
+}
+
+func (c *httpClient) sendError(taskID string, err error) {
+	c.postResult(map[string]interface{}{
+		"id":    taskID,
+		"error": err.Error(),
+	})
+}
+
+func (c *httpClient) postResult(body map[string]interface{}) {
+	reqBody, _ := json.Marshal(body)
 
 	resp, err := http.Post(
-		api_base_url+"/internal/task",
+		c.baseURL+"/internal/task",
 		"application/json",
 		bytes.NewBuffer(reqBody),
 	)
@@ -86,20 +213,55 @@ func sendResult(taskID string, result float64) {
 	}
 }
 
-func worker() {
-	for {
-		task := getTask()
+// worker computes tasks of the orchestrator until ctx is done
+func (c *httpClient) worker(ctx context.Context) {
+	for ctx.Err() == nil {
+		polled := time.Now()
+		task, err := c.getTask(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("Error getting task:", err)
+				sleep(ctx, 1*time.Second)
+			}
+			continue
+		}
 		if task == nil {
-			time.Sleep(1 * time.Second)
+			if time.Since(polled) < c.pollWait || c.pollWait <= 0 {
+				sleep(ctx, c.emptyPollBackoff)
+			}
+			continue
+		}
+		if task.Precision == precisionExact {
+			result, err := computeExactTask(task)
+			if err != nil {
+				c.sendError(task.ID, err)
+				continue
+			}
+			c.sendExactResult(task.ID, result)
 			continue
 		}
-		result := computeTask(task)
-		sendResult(task.ID, result)
+		result, err := computeTask(task)
+		if err != nil {
+			c.sendError(task.ID, err)
+			continue
+		}
+		c.sendResult(task.ID, result)
+	}
+}
+
+// sleep pauses for duration or until ctx is done
+func sleep(ctx context.Context, duration time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(duration):
 	}
 }
 
 func initBaseUrl() {
 	flag.StringVar(&api_base_url, "base-url", "http://127.0.0.1:8080", "Listen on IP address")
+	flag.DurationVar(&poll_wait, "poll-wait", 30*time.Second, "How long the orchestrator may hold a task request")
+	flag.StringVar(&protocol, "protocol", "http", "Protocol to talk to the orchestrator: http or grpc")
+	flag.StringVar(&grpc_address, "grpc-address", "127.0.0.1:9090", "Orchestrator gRPC address")
 	flag.Parse()
 }
 
@@ -110,9 +272,20 @@ func main() {
 		computingPower = 2
 	}
 
-	for i := 0; i < computingPower; i++ {
-		go worker()
+	switch protocol {
+	case "http":
+		client := &httpClient{baseURL: api_base_url, pollWait: poll_wait, emptyPollBackoff: 1 * time.Second}
+		for i := 0; i < computingPower; i++ {
+			go client.worker(context.Background())
+		}
+		select {}
+	case "grpc":
+		for {
+			err := runGRPC(context.Background(), computingPower)
+			log.Println("gRPC session closed:", err)
+			time.Sleep(1 * time.Second)
+		}
+	default:
+		log.Fatalf("Unknown protocol %q", protocol)
 	}
-
-	select {}
 }
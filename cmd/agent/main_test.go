@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -38,11 +41,14 @@ func TestGetTask(t *testing.T) {
 	server := setupTestServer()
 	defer server.Close()
 
-	// Set the API base URL to our test server
-	api_base_url = server.URL
+	// Point the client to our test server
+	client := &httpClient{baseURL: server.URL}
 
 	// Test getting a task
-	task := getTask()
+	task, err := client.getTask(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 	if task == nil {
 		t.Fatal("Expected task, got nil")
 	}
@@ -56,11 +62,31 @@ func TestGetTask(t *testing.T) {
 	}
 }
 
+func TestGetTaskNoTask(t *testing.T) {
+	var wait string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait = r.URL.Query().Get("wait")
+		http.Error(w, "No tasks available", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := &httpClient{baseURL: server.URL, pollWait: 5 * time.Second}
+
+	task, err := client.getTask(context.Background())
+	if task != nil || err != nil {
+		t.Errorf("getTask() = %v, %v, want nil, nil", task, err)
+	}
+	if wait != "5s" {
+		t.Errorf("Expected wait 5s in request, got %q", wait)
+	}
+}
+
 func TestComputeTask(t *testing.T) {
 	tests := []struct {
-		name     string
-		task     Task
-		expected float64
+		name        string
+		task        Task
+		expected    float64
+		expectedErr error
 	}{
 		{
 			name: "Addition",
@@ -102,6 +128,121 @@ func TestComputeTask(t *testing.T) {
 			},
 			expected: 2,
 		},
+		{
+			name: "Modulo",
+			task: Task{
+				Arg1:          -7,
+				Arg2:          3,
+				Operation:     "%",
+				OperationTime: 0,
+			},
+			expected: -1,
+		},
+		{
+			name: "Modulo by zero",
+			task: Task{
+				Arg1:          7,
+				Arg2:          0,
+				Operation:     "%",
+				OperationTime: 0,
+			},
+			expectedErr: ErrDivisionByZero,
+		},
+		{
+			name: "Exponentiation",
+			task: Task{
+				Arg1:          2,
+				Arg2:          10,
+				Operation:     "^",
+				OperationTime: 0,
+			},
+			expected: 1024,
+		},
+		{
+			name: "Root of a negative number",
+			task: Task{
+				Arg1:          -8,
+				Arg2:          0.5,
+				Operation:     "^",
+				OperationTime: 0,
+			},
+			expectedErr: ErrNotANumber,
+		},
+		{
+			name: "Square root",
+			task: Task{
+				Arg1:          16,
+				Operation:     "sqrt",
+				OperationTime: 0,
+			},
+			expected: 4,
+		},
+		{
+			name: "Square root of a negative number",
+			task: Task{
+				Arg1:          -1,
+				Operation:     "sqrt",
+				OperationTime: 0,
+			},
+			expectedErr: ErrNotANumber,
+		},
+		{
+			name: "Logarithm of zero",
+			task: Task{
+				Arg1:          0,
+				Operation:     "ln",
+				OperationTime: 0,
+			},
+			expectedErr: ErrOverflow,
+		},
+		{
+			name: "Maximum",
+			task: Task{
+				Arg1:          -3,
+				Arg2:          2,
+				Operation:     "max",
+				OperationTime: 0,
+			},
+			expected: 2,
+		},
+		{
+			name: "Comparison",
+			task: Task{
+				Arg1:          2,
+				Arg2:          2,
+				Operation:     "<=",
+				OperationTime: 0,
+			},
+			expected: 1,
+		},
+		{
+			name: "Boolean and",
+			task: Task{
+				Arg1:          0.5,
+				Arg2:          0,
+				Operation:     "&&",
+				OperationTime: 0,
+			},
+			expected: 0,
+		},
+		{
+			name: "Boolean not",
+			task: Task{
+				Arg1:          0,
+				Operation:     "!",
+				OperationTime: 0,
+			},
+			expected: 1,
+		},
+		{
+			name: "Negation",
+			task: Task{
+				Arg1:          10,
+				Operation:     "neg",
+				OperationTime: 0,
+			},
+			expected: -10,
+		},
 		{
 			name: "Invalid Operation",
 			task: Task{
@@ -110,13 +251,47 @@ func TestComputeTask(t *testing.T) {
 				Operation:     "invalid",
 				OperationTime: 0,
 			},
-			expected: 0,
+			expected:    0,
+			expectedErr: ErrUnknownOperation,
+		},
+		{
+			name: "Division by zero",
+			task: Task{
+				Arg1:          10,
+				Arg2:          0,
+				Operation:     "/",
+				OperationTime: 0,
+			},
+			expectedErr: ErrDivisionByZero,
+		},
+		{
+			name: "Overflow",
+			task: Task{
+				Arg1:          math.MaxFloat64,
+				Arg2:          10,
+				Operation:     "*",
+				OperationTime: 0,
+			},
+			expectedErr: ErrOverflow,
+		},
+		{
+			name: "Not a number",
+			task: Task{
+				Arg1:          math.NaN(),
+				Arg2:          1,
+				Operation:     "+",
+				OperationTime: 0,
+			},
+			expectedErr: ErrNotANumber,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := computeTask(&tt.task)
+			result, err := computeTask(&tt.task)
+			if err != tt.expectedErr {
+				t.Errorf("computeTask() error = %v, want %v", err, tt.expectedErr)
+			}
 			if result != tt.expected {
 				t.Errorf("computeTask() = %v, want %v", result, tt.expected)
 			}
@@ -129,37 +304,91 @@ func TestSendResult(t *testing.T) {
 	server := setupTestServer()
 	defer server.Close()
 
-	// Set the API base URL to our test server
-	api_base_url = server.URL
+	// Point the client to our test server
+	client := &httpClient{baseURL: server.URL}
 
 	// Test sending a result
-	sendResult("test-123", 15.0)
+	client.sendResult("test-123", 15.0)
 	// If we reach here without panic, the test passes
 	// In a real scenario, you might want to verify the request body
 }
 
+func TestSendError(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &httpClient{baseURL: server.URL}
+
+	client.sendError("test-123", ErrDivisionByZero)
+
+	if received["id"] != "test-123" {
+		t.Errorf("Expected id 'test-123', got %v", received["id"])
+	}
+	if received["error"] != "division_by_zero" {
+		t.Errorf("Expected error 'division_by_zero', got %v", received["error"])
+	}
+}
+
 func TestWorkerIntegration(t *testing.T) {
 	// Setup test server
 	server := setupTestServer()
 	defer server.Close()
 
-	// Set the API base URL to our test server
-	api_base_url = server.URL
+	// Point the client to our test server
+	client := &httpClient{baseURL: server.URL}
 
 	// Start worker in a goroutine
+	ctx, cancel := context.WithCancel(context.Background())
 	done := make(chan bool)
 	go func() {
-		worker()
+		client.worker(ctx)
 		done <- true
 	}()
 
 	// Let the worker run for a short time
 	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
 
 	// Test passes if we reach here without any panics
 	// In a real scenario, you might want to verify the complete flow
 }
 
+func TestWorkerBacksOffOnEmptyPoll(t *testing.T) {
+	var mutex sync.Mutex
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		polls++
+		mutex.Unlock()
+		http.Error(w, "No tasks available", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := &httpClient{baseURL: server.URL, emptyPollBackoff: 50 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		client.worker(ctx)
+		done <- true
+	}()
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	<-done
+
+	// A busy loop would make thousands of requests
+	mutex.Lock()
+	defer mutex.Unlock()
+	if polls < 2 || polls > 5 {
+		t.Errorf("Expected the worker to poll every 50ms, got %d polls in 200ms", polls)
+	}
+}
+
 func TestInitBaseUrl(t *testing.T) {
 	// Test cases
 	tests := []struct {
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// max_batch_size bounds the number of expressions of a batch request
+var max_batch_size = getEnvAsInt("MAX_BATCH_SIZE", 10000)
+
+// handleCalculateBatch creates the expressions of an array of calculate
+// requests. Items are validated one by one, the valid ones are stored
+// in one storage transaction and added under a single lock acquisition.
+// Results come in the order of items, each with the ID or the error a
+// single calculate request would get.
+func handleCalculateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+	var items []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil || len(items) == 0 {
+		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		return
+	}
+	if len(items) > max_batch_size {
+		http.Error(w, "Too many expressions", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]map[string]interface{}, len(items))
+	prepared := make([]*preparedExpression, len(items))
+	for idx, item := range items {
+		var req CalculateRequest
+		if err := json.Unmarshal(item, &req); err != nil {
+			results[idx] = requestErrorBody(errInvalidRequest)
+			continue
+		}
+		var err error
+		if prepared[idx], err = prepareExpression(generateID(), req); err != nil {
+			results[idx] = requestErrorBody(err)
+		}
+	}
+
+	var exprs []*Expression
+	var batchTasks []*Task
+	for _, item := range prepared {
+		if item == nil {
+			continue
+		}
+		settleRoot(item.expr, item.root)
+		exprs = append(exprs, item.expr)
+		batchTasks = append(batchTasks, item.tasks...)
+	}
+
+	// Nothing refers to the new expressions yet, so they are stored
+	// without holding mutex, in one transaction
+	var err error
+	if len(exprs) > 0 {
+		if err = store.SaveBatch(exprs, batchTasks); err != nil {
+			log.Println("Error saving batch:", err)
+		}
+	}
+
+	mutex.Lock()
+	for idx, item := range prepared {
+		if item == nil {
+			continue
+		}
+		if err != nil {
+			results[idx] = requestErrorBody(errStorage)
+			continue
+		}
+		registerExpression(item.expr, item.tasks)
+		results[idx] = map[string]interface{}{"id": item.expr.ID}
+	}
+	mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleCalculateBatch(t *testing.T) {
+	setupTest()
+
+	body := `[
+		{"expression": "2 + 3 * 4"},
+		{"expression": "2 +"},
+		{"expression": "a * x", "variables": {"a": 2}},
+		"not an object",
+		{"expression": "max(7)"},
+		{"expression": ""}
+	]`
+	rr := httptest.NewRecorder()
+	handleCalculateBatch(rr, createTestRequest("POST", "/api/v1/calculate/batch", body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Results []map[string]interface{}
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if len(response.Results) != 6 {
+		t.Fatalf("Expected 6 results, got %v", response.Results)
+	}
+
+	results := response.Results
+	if results[1]["kind"] != ErrUnexpectedEnd || results[2]["kind"] != "unbound_variable" {
+		t.Errorf("Expected parse errors of items 1 and 2, got %v %v", results[1], results[2])
+	}
+	for _, idx := range []int{3, 5} {
+		if results[idx]["error"] != errInvalidRequest.Error() {
+			t.Errorf("Expected item %d to be invalid, got %v", idx, results[idx])
+		}
+	}
+	if len(expressions) != 2 {
+		t.Errorf("Expected 2 stored expressions, got %d", len(expressions))
+	}
+
+	if expr := finishExpression(t, results[0]["id"].(string)); expr.Result != 14 {
+		t.Errorf("Expected result 14 of item 0, got %v", expr.Result)
+	}
+	if expr := expressions[results[4]["id"].(string)]; expr.Status != "completed" || expr.Result != 7 {
+		t.Errorf("Expected item 4 to be completed right away, got %+v", expr)
+	}
+}
+
+func TestHandleCalculateBatchInvalid(t *testing.T) {
+	setupTest()
+
+	maxSize := max_batch_size
+	max_batch_size = 2
+	defer func() { max_batch_size = maxSize }()
+
+	tests := []struct {
+		body           string
+		expectedStatus int
+	}{
+		{`[]`, http.StatusUnprocessableEntity},
+		{`{"expression": "1 + 2"}`, http.StatusUnprocessableEntity},
+		{`[{"expression": "1"}, {"expression": "2"}, {"expression": "3"}]`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handleCalculateBatch(rr, createTestRequest("POST", "/api/v1/calculate/batch", tt.body))
+		if rr.Code != tt.expectedStatus {
+			t.Errorf("%s: got %v, want %v", strings.TrimSpace(tt.body), rr.Code, tt.expectedStatus)
+		}
+	}
+	if len(expressions) != 0 {
+		t.Errorf("Expected no expressions to be stored, got %d", len(expressions))
+	}
+}
+
+// countingStorage counts the writes of new expressions
+type countingStorage struct {
+	*boltStorage
+	writes int
+}
+
+func (s *countingStorage) SaveExpression(expr *Expression) error {
+	s.writes++
+	return s.boltStorage.SaveExpression(expr)
+}
+
+func (s *countingStorage) SaveTasks(tasks []*Task) error {
+	s.writes++
+	return s.boltStorage.SaveTasks(tasks)
+}
+
+func (s *countingStorage) SaveBatch(exprs []*Expression, tasks []*Task) error {
+	s.writes++
+	return s.boltStorage.SaveBatch(exprs, tasks)
+}
+
+func TestHandleCalculateBatchSingleWrite(t *testing.T) {
+	setupTest()
+	storage := &countingStorage{boltStorage: setupBoltStorage(t)}
+	store = storage
+	defer func() { store = memoryStorage{} }()
+
+	body := `[{"expression": "2 + 3 * 4"}, {"expression": "7"}, {"expression": "1 -"}]`
+	rr := httptest.NewRecorder()
+	handleCalculateBatch(rr, createTestRequest("POST", "/api/v1/calculate/batch", body))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if storage.writes != 1 {
+		t.Errorf("Expected the batch to be stored in 1 write, got %d", storage.writes)
+	}
+
+	loadedExpressions, _ := storage.LoadExpressions()
+	loadedTasks, _ := storage.LoadTasks()
+	if len(loadedExpressions) != 2 || len(loadedTasks) != 2 {
+		t.Errorf("Expected 2 expressions and 2 tasks to be stored, got %d and %d", len(loadedExpressions), len(loadedTasks))
+	}
+	if len(expressions) != 2 || len(tasks) != 2 {
+		t.Errorf("Expected 2 expressions and 2 tasks in memory, got %d and %d", len(expressions), len(tasks))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEvaluateConditional(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]float64
+		expected   float64
+	}{
+		{"if(x > 10, x * 0.9, x)", map[string]float64{"x": 20}, 18},
+		{"if(x > 10, x * 0.9, x)", map[string]float64{"x": 5}, 5},
+		{"if(x > 0, if(x > 10, 2, x + 1), -x) * 10", map[string]float64{"x": 3}, 40},
+		{"if(x > 0, if(x > 10, 2, x + 1), -x) * 10", map[string]float64{"x": -3}, 30},
+		{"if(!(x < 0) && x > 2, 1, 0)", map[string]float64{"x": 3}, 1},
+		{"if(1 == 2, x / 0, x + 1)", map[string]float64{"x": 1}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluateRequest(t, map[string]interface{}{
+				"expression": tt.expression,
+				"variables":  tt.variables,
+			})
+			if expr.Status != "completed" || expr.Result != tt.expected {
+				t.Errorf("Got %s %v, want completed %v", expr.Status, expr.Result, tt.expected)
+			}
+			if len(tasks) != 0 {
+				t.Errorf("Expected all tasks to be removed, got %d", len(tasks))
+			}
+		})
+	}
+}
+
+func TestConditionalDispatchesSelectedBranch(t *testing.T) {
+	setupTest()
+
+	body := `{"expression": "if(x > 1, x * 2, (x + 100) / 3)", "variables": {"x": 5}}`
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", body))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	var dispatched []string
+	for _, result := range []float64{1, 10} {
+		task := dispatchTask(t.Context(), 0)
+		if task == nil {
+			t.Fatalf("Expected a task after %v", dispatched)
+		}
+		dispatched = append(dispatched, task.Operation)
+		if next := dispatchTask(t.Context(), 0); next != nil {
+			t.Fatalf("Expected a single ready task, got %s too", next.Operation)
+		}
+		if err := submitResult(task.ID, result, "", ""); err != nil {
+			t.Fatalf("submitResult() error = %v", err)
+		}
+	}
+
+	if dispatched[0] != ">" || dispatched[1] != "*" {
+		t.Errorf("Expected > and * to be dispatched, got %v", dispatched)
+	}
+	expr := expressions[created["id"]]
+	if expr.Status != "completed" || expr.Result != 10 {
+		t.Errorf("Expected completed with result 10, got %s %v", expr.Status, expr.Result)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("Expected all tasks to be removed, got %d", len(tasks))
+	}
+}
+
+func TestConditionalWithLiteralCondition(t *testing.T) {
+	tasks, _, err := parseExpression("if(2, 1 + 2, 3 * 4) + if(0, 5 - 6, 7)", EvalOptions{}, "test-expr-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].Operation != "+" || tasks[1].Operation != "+" {
+		t.Fatalf("Expected only the selected branches, got %d tasks", len(tasks))
+	}
+	if operand := tasks[1].Operands[1]; operand.TaskID != "" || operand.Value != 7 {
+		t.Errorf("Expected the else literal as operand, got %+v", operand)
+	}
+}
+
+func TestEvaluateConditionalExact(t *testing.T) {
+	for precision, expected := range map[string]float64{precisionFloat: 0, precisionExact: 1} {
+		setupTest()
+
+		expr := evaluateRequest(t, map[string]interface{}{
+			"expression": "if(0.1 + 0.2 == 0.3, 1, 0)",
+			"precision":  precision,
+		})
+		if expr.Status != "completed" || expr.Result != expected {
+			t.Errorf("%s: got %s %v, want completed %v", precision, expr.Status, expr.Result, expected)
+		}
+	}
+}
+
+func TestCancelConditional(t *testing.T) {
+	setupTest()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "if(x > 1, x * 2, x)", "variables": {"x": 5}}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	task := dispatchTask(t.Context(), 0)
+	rr = httptest.NewRecorder()
+	handleExpressionByID(rr, createTestRequest("DELETE", "/api/v1/expressions/"+created["id"], ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if err := submitResult(task.ID, 1, "", ""); err != errExpressionCancelled {
+		t.Errorf("Expected errExpressionCancelled, got %v", err)
+	}
+	if len(tasks) != 0 || len(sched.conditions) != 0 {
+		t.Errorf("Expected no tasks left, got %d tasks and %d conditions", len(tasks), len(sched.conditions))
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventCreated        = "created"
+	eventTaskDispatched = "task_dispatched"
+	eventTaskCompleted  = "task_completed"
+	eventStatus         = "status"
+)
+
+// max_buffered_events is the backlog of a subscriber, a client that
+// falls further behind is disconnected
+const max_buffered_events = 256
+
+var event_keepalive_interval = 15 * time.Second
+
+// event is one Server-Sent Event, Data is encoded as JSON
+type event struct {
+	Name         string
+	ExpressionID string
+	Data         interface{}
+}
+
+type subscription struct {
+	// expressionIDs filters the events, nil for all expressions
+	expressionIDs map[string]bool
+	events        chan event
+}
+
+// eventHub fans out events to the open streams. It has its own lock,
+// publish is called with mutex held and never blocks.
+type eventHub struct {
+	mutex       sync.Mutex
+	subscribers map[*subscription]struct{}
+}
+
+var events = &eventHub{subscribers: make(map[*subscription]struct{})}
+
+// subscribe opens a subscription to the events of expressionIDs, more
+// can be added with watch
+func (h *eventHub) subscribe(expressionIDs ...string) *subscription {
+	sub := h.subscribeAll()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	sub.expressionIDs = make(map[string]bool)
+	for _, id := range expressionIDs {
+		sub.expressionIDs[id] = true
+	}
+	return sub
+}
+
+// subscribeAll opens a subscription to the events of all expressions
+func (h *eventHub) subscribeAll() *subscription {
+	sub := &subscription{events: make(chan event, max_buffered_events)}
+	h.mutex.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mutex.Unlock()
+	return sub
+}
+
+// watch adds an expression to a filtered subscription. IDs are known
+// before the expression is created, so no event of it is missed.
+func (h *eventHub) watch(sub *subscription, expressionID string) {
+	h.mutex.Lock()
+	sub.expressionIDs[expressionID] = true
+	h.mutex.Unlock()
+}
+
+func (h *eventHub) unwatch(sub *subscription, expressionID string) {
+	h.mutex.Lock()
+	delete(sub.expressionIDs, expressionID)
+	h.mutex.Unlock()
+}
+
+func (h *eventHub) unsubscribe(sub *subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.subscribers[sub]; exists {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (h *eventHub) publish(e event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub := range h.subscribers {
+		if sub.expressionIDs != nil && !sub.expressionIDs[e.ExpressionID] {
+			continue
+		}
+		h.send(sub, e)
+	}
+}
+
+// deliver sends an event to one subscriber only
+func (h *eventHub) deliver(sub *subscription, e event) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, exists := h.subscribers[sub]; exists {
+		h.send(sub, e)
+	}
+}
+
+func (h *eventHub) send(sub *subscription, e event) {
+	select {
+	case sub.events <- e:
+	default:
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func publishCreated(expr *Expression) {
+	events.publish(event{Name: eventCreated, ExpressionID: expr.ID, Data: expressionView(expr)})
+}
+
+func publishTaskDispatched(task *Task) {
+	events.publish(event{
+		Name:         eventTaskDispatched,
+		ExpressionID: task.ExpressionID,
+		Data: map[string]interface{}{
+			"expression_id": task.ExpressionID,
+			"task_id":       task.ID,
+			"operation":     task.Operation,
+			"attempt":       task.Attempts,
+		},
+	})
+}
+
+func publishTaskCompleted(task *Task) {
+	data := map[string]interface{}{
+		"expression_id": task.ExpressionID,
+		"task_id":       task.ID,
+		"operation":     task.Operation,
+		"result":        task.Result,
+	}
+	if task.ExactResult != "" {
+		data["exact_result"] = task.ExactResult
+	}
+	events.publish(event{Name: eventTaskCompleted, ExpressionID: task.ExpressionID, Data: data})
+}
+
+// publishStatus announces the final status of an expression, the
+// stream of the expression ends with it
+func publishStatus(expr *Expression) {
+	events.publish(event{Name: eventStatus, ExpressionID: expr.ID, Data: expressionView(expr)})
+}
+
+// handleEvents streams the events of all expressions
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+
+	sub := events.subscribeAll()
+	defer events.unsubscribe(sub)
+	streamEvents(w, r, sub, false)
+}
+
+// handleExpressionEvents streams the events of one expression until its
+// final status. A finished expression gets only the status event.
+func handleExpressionEvents(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+
+	// Subscribing under mutex, so no transition slips in between
+	mutex.Lock()
+	expr, exists := expressions[id]
+	var view map[string]interface{}
+	var sub *subscription
+	if exists && expr.Status != "pending" {
+		view = expressionView(expr)
+	} else if exists {
+		sub = events.subscribe(id)
+	}
+	mutex.Unlock()
+
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	if sub == nil {
+		setEventHeaders(w)
+		writeEvent(w, event{Name: eventStatus, ExpressionID: id, Data: view})
+		return
+	}
+	defer events.unsubscribe(sub)
+	streamEvents(w, r, sub, true)
+}
+
+// streamEvents writes the events of sub until the client goes away,
+// the subscription is dropped or, with untilStatus, a status event is
+// sent
+func streamEvents(w http.ResponseWriter, r *http.Request, sub *subscription, untilStatus bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	setEventHeaders(w)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(event_keepalive_interval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case e, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			flusher.Flush()
+			if untilStatus && e.Name == eventStatus {
+				return
+			}
+		}
+	}
+}
+
+func setEventHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+}
+
+func writeEvent(w http.ResponseWriter, e event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data)
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testEvent struct {
+	Name string
+	Data map[string]interface{}
+}
+
+// readEvent reads the next event of an SSE stream, skipping comments
+func readEvent(t *testing.T, reader *bufio.Reader) (testEvent, error) {
+	t.Helper()
+
+	var e testEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return e, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.Name != "":
+			return e, nil
+		case strings.HasPrefix(line, "event: "):
+			e.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.Data); err != nil {
+				t.Fatalf("Failed to decode event data %q: %v", line, err)
+			}
+		}
+	}
+}
+
+func openEventStream(t *testing.T, url string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s returned %v", url, resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %s", contentType)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestExpressionEvents(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(http.HandlerFunc(handleExpressionByID))
+	defer server.Close()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "2 + 3 * 4"}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	resp, reader := openEventStream(t, server.URL+"/api/v1/expressions/"+created["id"]+"/events")
+	defer resp.Body.Close()
+
+	finishExpression(t, created["id"])
+
+	expected := []struct {
+		name      string
+		operation string
+	}{
+		{eventTaskDispatched, "*"},
+		{eventTaskCompleted, "*"},
+		{eventTaskDispatched, "+"},
+		{eventTaskCompleted, "+"},
+		{eventStatus, ""},
+	}
+	for _, want := range expected {
+		e, err := readEvent(t, reader)
+		if err != nil {
+			t.Fatalf("Expected %s event, got %v", want.name, err)
+		}
+		if e.Name != want.name {
+			t.Fatalf("Expected %s event, got %s %v", want.name, e.Name, e.Data)
+		}
+		if want.operation != "" && e.Data["operation"] != want.operation {
+			t.Errorf("Expected %s of %s, got %v", want.name, want.operation, e.Data)
+		}
+		if want.name == eventStatus && (e.Data["status"] != "completed" || e.Data["result"] != float64(14)) {
+			t.Errorf("Expected completed status with result 14, got %v", e.Data)
+		}
+	}
+
+	if _, err := readEvent(t, reader); err != io.EOF {
+		t.Errorf("Expected the stream to end after the status event, got %v", err)
+	}
+}
+
+func TestExpressionEventsFinished(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(http.HandlerFunc(handleExpressionByID))
+	defer server.Close()
+
+	expr := evaluate(t, "2 * 3")
+
+	resp, reader := openEventStream(t, server.URL+"/api/v1/expressions/"+expr.ID+"/events")
+	defer resp.Body.Close()
+
+	e, err := readEvent(t, reader)
+	if err != nil || e.Name != eventStatus || e.Data["status"] != expr.Status {
+		t.Errorf("Expected %s status event, got %v %v", expr.Status, e, err)
+	}
+	if _, err := readEvent(t, reader); err != io.EOF {
+		t.Errorf("Expected the stream to end, got %v", err)
+	}
+
+	missing, err := http.Get(server.URL + "/api/v1/expressions/nonexistent/events")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown expression, got %v", missing.StatusCode)
+	}
+}
+
+func TestGlobalEvents(t *testing.T) {
+	setupTest()
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+
+	resp, reader := openEventStream(t, server.URL+"/api/v1/events")
+	defer resp.Body.Close()
+
+	first := evaluate(t, "7 - 2")
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "1 + 1"}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	rr = httptest.NewRecorder()
+	handleExpressionByID(rr, createTestRequest("DELETE", "/api/v1/expressions/"+created["id"], ""))
+
+	statuses := map[string]interface{}{}
+	for len(statuses) < 2 {
+		e, err := readEvent(t, reader)
+		if err != nil {
+			t.Fatalf("Expected more events, got %v", err)
+		}
+		if e.Name == eventStatus {
+			statuses[e.Data["id"].(string)] = e.Data["status"]
+		}
+	}
+	if statuses[first.ID] != "completed" || statuses[created["id"]] != "cancelled" {
+		t.Errorf("Unexpected statuses %v", statuses)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Precision modes of an expression. In the exact mode operands and
+// results travel as rational strings such as "3/10" and agents compute
+// them with math/big.
+const (
+	precisionFloat = "float"
+	precisionExact = "exact"
+)
+
+func validPrecision(precision string) bool {
+	return precision == "" || precision == precisionFloat || precision == precisionExact
+}
+
+// exactOperand builds a literal operand of the exact mode from a number
+// token or a bound variable
+func exactOperand(number string) Operand {
+	value, _ := new(big.Rat).SetString(number)
+	return Operand{Value: approximate(value), Exact: value.RatString()}
+}
+
+// approximate is the float64 nearest to value. A rational beyond the
+// float64 range is clamped, JSON cannot carry an infinity.
+func approximate(value *big.Rat) float64 {
+	approx, _ := value.Float64()
+	if math.IsInf(approx, 0) {
+		return math.Copysign(math.MaxFloat64, approx)
+	}
+	return approx
+}
+
+func exactVariable(value float64) Operand {
+	// The shortest representation is what the client most likely wrote
+	return exactOperand(strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func negateExact(operand Operand) Operand {
+	value, _ := new(big.Rat).SetString(operand.Exact)
+	return Operand{Value: -operand.Value, Exact: value.Neg(value).RatString()}
+}
+
+// formatExact renders a rational as a decimal when it has a finite
+// decimal expansion, and as a fraction otherwise.
+func formatExact(value *big.Rat) string {
+	denominator := new(big.Int).Set(value.Denom())
+	digits := 0
+	for _, factor := range []int64{2, 5} {
+		divisor := big.NewInt(factor)
+		count := 0
+		remainder := new(big.Int)
+		for {
+			quotient, mod := new(big.Int).QuoRem(denominator, divisor, remainder)
+			if mod.Sign() != 0 {
+				break
+			}
+			denominator = quotient
+			count++
+		}
+		digits = max(digits, count)
+	}
+
+	if denominator.Cmp(big.NewInt(1)) != 0 {
+		return value.RatString()
+	}
+	return value.FloatString(digits)
+}
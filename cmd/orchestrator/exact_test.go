@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatExact(t *testing.T) {
+	tests := map[string]string{
+		"3/10":   "0.3",
+		"-1/8":   "-0.125",
+		"42":     "42",
+		"1/3":    "1/3",
+		"7/20":   "0.35",
+		"1/6":    "1/6",
+		"-5/1":   "-5",
+		"1/1024": "0.0009765625",
+	}
+
+	for input, expected := range tests {
+		value, _ := new(big.Rat).SetString(input)
+		if got := formatExact(value); got != expected {
+			t.Errorf("formatExact(%s) = %s, want %s", input, got, expected)
+		}
+	}
+}
+
+func TestEvaluateExact(t *testing.T) {
+	tests := []struct {
+		expression string
+		variables  map[string]float64
+		expected   string
+		result     float64
+	}{
+		{"0.1 + 0.2", nil, "0.3", 0.3},
+		{"1 / 3 * 3", nil, "1", 1},
+		{"-(0.1 + 0.2) * 3", nil, "-0.9", -0.9},
+		{"price * 3 - 0.3", map[string]float64{"price": 0.1}, "0", 0},
+		{"-0.1", nil, "-0.1", -0.1},
+		{"2 / 3", nil, "2/3", 2.0 / 3},
+		{"1e-1 + 0x2 * 1e-1", nil, "0.3", 0.3},
+		{"1e400 / 1e399", nil, "10", 10},
+		{"-1e400 * 0 + 1", nil, "1", 1},
+		{"1e400", nil, "1" + strings.Repeat("0", 400), math.MaxFloat64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluateRequest(t, map[string]interface{}{
+				"expression": tt.expression,
+				"variables":  tt.variables,
+				"precision":  precisionExact,
+			})
+			if expr.Status != "completed" {
+				t.Fatalf("Expected status completed, got %s", expr.Status)
+			}
+			if expr.ExactResult != tt.expected || expr.Result != tt.result {
+				t.Errorf("%s = %s (%v), want %s (%v)", tt.expression, expr.ExactResult, expr.Result, tt.expected, tt.result)
+			}
+		})
+	}
+}
+
+func TestHandleTaskInvalidExactResult(t *testing.T) {
+	setupTest()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "0.1 + 0.2", "precision": "exact"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	task := dispatchTask(t.Context(), 0)
+	if task.ExactArg1 != "1/10" || task.ExactArg2 != "1/5" {
+		t.Errorf("Expected exact arguments 1/10 and 1/5, got %s and %s", task.ExactArg1, task.ExactArg2)
+	}
+
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("POST", "/internal/task", `{"id": "`+task.ID+`", "result": 0.3}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+
+	rr = httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "1 + 2", "precision": "decimal"}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Unknown precision: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Raikh/calc_micro/internal/ast"
+)
+
+// Formula is an expression registered once and run with different
+// variable bindings. The syntax tree is parsed at registration and kept
+// in memory, so a run only stamps out tasks.
+type Formula struct {
+	ID                     string
+	Expr                   string
+	ImplicitMultiplication bool
+	tree                   ast.Node
+}
+
+func handleCreateFormula(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+	var req struct {
+		Expression             string `json:"expression"`
+		ImplicitMultiplication bool   `json:"implicit_multiplication"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	req.Expression = strings.TrimSpace(req.Expression)
+	if req.Expression == "" {
+		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	tree, err := parse(req.Expression, req.ImplicitMultiplication)
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+
+	formula := &Formula{
+		ID:                     generateID(),
+		Expr:                   req.Expression,
+		ImplicitMultiplication: req.ImplicitMultiplication,
+		tree:                   tree,
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if err := store.SaveFormula(formula); err != nil {
+		log.Println("Error saving formula:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	formulas[formula.ID] = formula
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": formula.ID})
+}
+
+// handleRunFormula serves POST /api/v1/formulas/{id}/run. The created
+// expression is tracked under /api/v1/expressions like any other.
+func handleRunFormula(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(r.URL.Path[len("/api/v1/formulas/"):], "/")
+	if action != "run" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+
+	var req EvalOptions
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !validPrecision(req.Precision) || !validOptimization(req.Optimization) {
+		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Formulas are never changed after registration
+	mutex.Lock()
+	formula, exists := formulas[id]
+	mutex.Unlock()
+
+	if !exists {
+		http.Error(w, "Formula not found", http.StatusNotFound)
+		return
+	}
+
+	exprID := generateID()
+	tasksForExpr, root, err := lowerTree(formula.tree, req, exprID)
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+
+	// The expression is parsed with the flag of the formula
+	req.ImplicitMultiplication = formula.ImplicitMultiplication
+	expr := newExpression(exprID, formula.Expr, req)
+	if err := createExpression(expr, tasksForExpr, root); err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	writeCreated(w, expr)
+}
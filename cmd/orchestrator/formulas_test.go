@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func createFormula(t *testing.T, expression string) string {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"expression": expression})
+	rr := httptest.NewRecorder()
+	handleCreateFormula(rr, createTestRequest("POST", "/api/v1/formulas", string(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var created map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	return created["id"]
+}
+
+func TestRunFormula(t *testing.T) {
+	setupTest()
+
+	id := createFormula(t, "a * x + b")
+
+	tests := []struct {
+		variables string
+		expected  float64
+	}{
+		{`{"a": 2, "x": 3, "b": 1}`, 7},
+		{`{"a": -1, "x": 4, "b": 0.5}`, -3.5},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		handleRunFormula(rr, createTestRequest("POST", "/api/v1/formulas/"+id+"/run", `{"variables": `+tt.variables+`}`))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+
+		var created map[string]string
+		if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+
+		expr := finishExpression(t, created["id"])
+		if expr.Status != "completed" || expr.Result != tt.expected {
+			t.Errorf("Run with %s: got %s %v, want completed %v", tt.variables, expr.Status, expr.Result, tt.expected)
+		}
+		if expr.Expr != "a * x + b" {
+			t.Errorf("Expected expression of the formula, got %q", expr.Expr)
+		}
+	}
+}
+
+func TestFormulaErrors(t *testing.T) {
+	setupTest()
+
+	rr := httptest.NewRecorder()
+	handleCreateFormula(rr, createTestRequest("POST", "/api/v1/formulas", `{"expression": "a * (x"}`))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Malformed formula: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if len(formulas) != 0 {
+		t.Errorf("Expected no formulas to be stored, got %d", len(formulas))
+	}
+
+	id := createFormula(t, "a * x")
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"Unknown formula", "/api/v1/formulas/missing/run", `{"variables": {}}`, http.StatusNotFound},
+		{"Unknown action", "/api/v1/formulas/" + id, `{"variables": {}}`, http.StatusNotFound},
+		{"Unbound variables", "/api/v1/formulas/" + id + "/run", `{"variables": {"a": 1}}`, http.StatusUnprocessableEntity},
+		{"Invalid JSON", "/api/v1/formulas/" + id + "/run", `{"variables": `, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handleRunFormula(rr, createTestRequest("POST", tt.path, tt.body))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+
+	if len(expressions) != 0 {
+		t.Errorf("Expected no expressions to be stored, got %d", len(expressions))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+// Function describes a built-in function callable in expressions. Each
+// call is computed by agents as a task with the function name as its
+// operation, except "if" that the orchestrator resolves itself.
+type Function struct {
+	// Arity is the number of arguments of one task
+	Arity int
+	// Variadic functions accept one or more arguments and are reduced
+	// pairwise, Arity of a variadic function is always 2
+	Variadic bool
+}
+
+var functions = map[string]Function{
+	"abs":   {Arity: 1},
+	"sqrt":  {Arity: 1},
+	"exp":   {Arity: 1},
+	"ln":    {Arity: 1},
+	"log":   {Arity: 1},
+	"sin":   {Arity: 1},
+	"cos":   {Arity: 1},
+	"tan":   {Arity: 1},
+	"floor": {Arity: 1},
+	"ceil":  {Arity: 1},
+	"round": {Arity: 1},
+	"min":   {Arity: 2, Variadic: true},
+	"max":   {Arity: 2, Variadic: true},
+	// if(condition, then, else) only runs the selected branch
+	"if": {Arity: 3},
+}
+
+// checkArguments validates the number of arguments of a function call
+func checkArguments(function *Token, args int) error {
+	fn := functions[function.Value]
+	if (fn.Variadic && args >= 1) || (!fn.Variadic && args == fn.Arity) {
+		return nil
+	}
+	return &SyntaxError{ErrArgumentCount, function.Value, function.Position}
+}
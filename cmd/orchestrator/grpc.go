@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Raikh/calc_micro/internal/agentpb"
+	"google.golang.org/grpc"
+)
+
+// max_agent_slots caps the number of tasks pushed to one agent at once
+const max_agent_slots = 1024
+
+type agentServer struct {
+	agentpb.UnimplementedAgentServiceServer
+}
+
+func serveGRPC(address string) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal("Error listening for gRPC agents: ", err)
+	}
+
+	server := grpc.NewServer()
+	agentpb.RegisterAgentServiceServer(server, agentServer{})
+	log.Fatal(server.Serve(listener))
+}
+
+// agentSession is the state of one Connect stream
+type agentSession struct {
+	slots chan struct{}
+
+	mutex sync.Mutex
+	// outstanding maps tasks pushed to the agent to their lease deadline
+	outstanding map[string]time.Time
+}
+
+func (agentServer) Connect(stream agentpb.AgentService_ConnectServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	session := &agentSession{
+		slots:       make(chan struct{}, max_agent_slots),
+		outstanding: make(map[string]time.Time),
+	}
+
+	sendDone := make(chan struct{})
+	go func() {
+		defer close(sendDone)
+		if err := session.sendTasks(ctx, stream); err != nil {
+			log.Println("Error sending task to agent:", err)
+		}
+	}()
+	defer func() {
+		cancel()
+		<-sendDone
+		session.requeueOutstanding()
+	}()
+
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch m := msg.Message.(type) {
+		case *agentpb.AgentMessage_Ready:
+			session.addSlots(int(m.Ready.Slots))
+		case *agentpb.AgentMessage_Result:
+			session.untrack(m.Result.Id)
+			if err := submitResult(m.Result.Id, m.Result.Result, m.Result.ExactResult, m.Result.Error); err != nil {
+				log.Println("Error submitting result:", err)
+			}
+			session.addSlots(1)
+		}
+	}
+}
+
+func (s *agentSession) addSlots(count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// sendTasks pushes a task to the agent for every free slot
+func (s *agentSession) sendTasks(ctx context.Context, stream agentpb.AgentService_ConnectServer) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.slots:
+		}
+
+		var task *Task
+		for task == nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			task = dispatchTask(ctx, max_task_wait)
+		}
+
+		s.track(task)
+		err := stream.Send(&agentpb.Task{
+			Id:            task.ID,
+			Arg1:          task.Arg1,
+			Arg2:          task.Arg2,
+			Operation:     task.Operation,
+			OperationTime: int32(task.OperationTime),
+			Precision:     task.Precision,
+			ExactArg1:     task.ExactArg1,
+			ExactArg2:     task.ExactArg2,
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *agentSession) track(task *Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.outstanding[task.ID] = task.LeaseDeadline
+}
+
+func (s *agentSession) untrack(taskID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.outstanding, taskID)
+}
+
+// requeueOutstanding hands tasks of a disconnected agent out again
+// without waiting for their leases to expire
+func (s *agentSession) requeueOutstanding() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for id, deadline := range s.outstanding {
+		// The lease may have expired and the task went to another agent
+		if task, exists := sched.leased[id]; exists && task.LeaseDeadline.Equal(deadline) {
+			sched.requeue(task)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Raikh/calc_micro/internal/agentpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Helper function to open a Connect stream to an in-process server
+func connectTestAgent(t *testing.T, ctx context.Context) agentpb.AgentService_ConnectClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	agentpb.RegisterAgentServiceServer(server, agentServer{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := agentpb.NewAgentServiceClient(conn).Connect(ctx)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	return stream
+}
+
+func TestGRPCConnect(t *testing.T) {
+	setupTest()
+
+	stream := connectTestAgent(t, context.Background())
+	stream.Send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Ready{Ready: &agentpb.Ready{Slots: 1}}})
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "(1 + 2) * 3"}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	id := created["id"]
+
+	for _, expected := range []string{"+", "*"} {
+		task, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		if task.Operation != expected {
+			t.Fatalf("Expected operation %s, got %s", expected, task.Operation)
+		}
+
+		var result float64
+		if task.Operation == "+" {
+			result = task.Arg1 + task.Arg2
+		} else {
+			result = task.Arg1 * task.Arg2
+		}
+		stream.Send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Result{
+			Result: &agentpb.Result{Id: task.Id, Result: result},
+		}})
+	}
+	stream.CloseSend()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		expr := expressions[id]
+		status, result := expr.Status, expr.Result
+		mutex.Unlock()
+
+		if status == "completed" {
+			if result != 9 {
+				t.Errorf("Expected result 9, got %v", result)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expression was not completed over gRPC")
+}
+
+func TestGRPCDisconnectRequeuesTasks(t *testing.T) {
+	setupTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := connectTestAgent(t, ctx)
+	stream.Send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Ready{Ready: &agentpb.Ready{Slots: 1}}})
+
+	handleCalculate(httptest.NewRecorder(), createTestRequest("POST", "/api/v1/calculate", `{"expression": "2 + 3"}`))
+
+	task, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("Recv() error = %v", err)
+	}
+	cancel()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		requeued := !tasks[task.Id].IsProcessing
+		mutex.Unlock()
+
+		if requeued {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Task of a disconnected agent was not re-queued")
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/Raikh/calc_micro/internal/ast"
+)
+
+// parseExpression splits expression into tasks for agents, substituting
+// variables from bindings. The returned operand holds the result: the
+// last task or, for an expression without operations, a literal value.
+func parseExpression(expr string, options EvalOptions, expressionID string) ([]*Task, Operand, error) {
+	tree, err := parse(expr, options.ImplicitMultiplication)
+	if err != nil {
+		return nil, Operand{}, err
+	}
+	return lowerTree(tree, options, expressionID)
+}
+
+// lowering collects the tasks of one expression
+type lowering struct {
+	options      EvalOptions
+	expressionID string
+	tasks        []*Task
+	unbound      []string
+	// memo maps the operationKey of every task in scope to its result
+	memo map[string]Operand
+}
+
+// lowerTree stamps out fresh tasks of an expression from its syntax
+// tree, it is shared by submitted expressions and formula runs. Tasks
+// come in evaluation order, dependencies first.
+func lowerTree(tree ast.Node, options EvalOptions, expressionID string) ([]*Task, Operand, error) {
+	l := &lowering{options: options, expressionID: expressionID, tasks: []*Task{}, memo: map[string]Operand{}}
+	if !l.exact() {
+		if err := checkRange(tree); err != nil {
+			return nil, Operand{}, err
+		}
+	}
+	if options.Optimization == optimizationFold {
+		tree = l.foldConstants(tree)
+	}
+	result := l.lower(tree)
+	if len(l.unbound) > 0 {
+		return nil, Operand{}, &UnboundVariablesError{Names: l.unbound}
+	}
+	return l.tasks, result, nil
+}
+
+// checkRange rejects a literal float64 cannot hold, the exact mode
+// takes any literal
+func checkRange(node ast.Node) error {
+	switch n := node.(type) {
+	case *ast.Number:
+		if _, err := strconv.ParseFloat(n.Value, 64); err != nil {
+			return &SyntaxError{ErrNumberOutOfRange, n.Value, n.Position}
+		}
+	case *ast.Unary:
+		return checkRange(n.Operand)
+	case *ast.Binary:
+		if err := checkRange(n.Left); err != nil {
+			return err
+		}
+		return checkRange(n.Right)
+	case *ast.Call:
+		for _, argument := range n.Arguments {
+			if err := checkRange(argument); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *lowering) exact() bool {
+	return l.options.Precision == precisionExact
+}
+
+func (l *lowering) literal(number string) Operand {
+	if l.exact() {
+		return exactOperand(number)
+	}
+	value, _ := strconv.ParseFloat(number, 64)
+	return Operand{Value: value}
+}
+
+func (l *lowering) lower(node ast.Node) Operand {
+	switch n := node.(type) {
+	case *ast.Number:
+		return l.literal(n.Value)
+	case *ast.Variable:
+		value, bound := l.options.Variables[n.Name]
+		if !bound && !slices.Contains(l.unbound, n.Name) {
+			l.unbound = append(l.unbound, n.Name)
+		}
+		if l.exact() {
+			return exactVariable(value)
+		}
+		return Operand{Value: value}
+	case *ast.Unary:
+		operand := l.lower(n.Operand)
+		if n.Operator == "!" {
+			return l.task("!", operand)
+		}
+		// Signed literals are folded right here, only negation of
+		// a computed subexpression is dispatched to agents
+		if operand.TaskID == "" {
+			if l.exact() {
+				return negateExact(operand)
+			}
+			return Operand{Value: -operand.Value}
+		}
+		return l.task("neg", operand)
+	case *ast.Binary:
+		left := l.lower(n.Left)
+		right := l.lower(n.Right)
+		return l.task(n.Operator, left, right)
+	case *ast.Call:
+		if n.Function == "if" {
+			return l.lowerConditional(n)
+		}
+		operands := make([]Operand, len(n.Arguments))
+		for idx, argument := range n.Arguments {
+			operands[idx] = l.lower(argument)
+		}
+		if !functions[n.Function].Variadic {
+			return l.task(n.Function, operands...)
+		}
+		// Variadic calls are reduced pairwise from the right
+		result := operands[len(operands)-1]
+		for idx := len(operands) - 2; idx >= 0; idx-- {
+			result = l.task(n.Function, operands[idx], result)
+		}
+		return result
+	default:
+		panic(fmt.Sprintf("unexpected node %T", node))
+	}
+}
+
+// task adds a task unless an identical one is already in scope
+func (l *lowering) task(operation string, operands ...Operand) Operand {
+	var key string
+	if l.options.Optimization != optimizationNone {
+		key = operationKey(operation, operands)
+		if result, exists := l.memo[key]; exists {
+			return result
+		}
+	}
+
+	task := &Task{
+		ID:            generateID(),
+		ExpressionID:  l.expressionID,
+		Operation:     operation,
+		OperationTime: getOperationTime(operation),
+		Operands:      operands,
+	}
+	if l.exact() {
+		task.Precision = precisionExact
+	}
+	l.tasks = append(l.tasks, task)
+	result := Operand{TaskID: task.ID}
+	if key != "" {
+		l.memo[key] = result
+	}
+	return result
+}
+
+// lowerConditional lowers if(condition, then, else). Both branches are
+// lowered, so unbound variables are reported in either of them. A
+// literal condition selects the branch right away. Otherwise an "if"
+// task gates the tasks of both branches, they stay dormant until the
+// condition is computed. Branches may reuse tasks built before them,
+// but not each other's, a dropped branch takes its tasks away.
+func (l *lowering) lowerConditional(call *ast.Call) Operand {
+	condition := l.lower(call.Arguments[0])
+	memo := l.memo
+	thenStart := len(l.tasks)
+	l.memo = maps.Clone(memo)
+	then := l.lower(call.Arguments[1])
+	elseStart := len(l.tasks)
+	l.memo = maps.Clone(memo)
+	otherwise := l.lower(call.Arguments[2])
+	l.memo = memo
+
+	if condition.TaskID == "" {
+		if isTruthy(condition.Value, condition.Exact) {
+			l.tasks = l.tasks[:elseStart]
+			return then
+		}
+		l.tasks = append(l.tasks[:thenStart], l.tasks[elseStart:]...)
+		return otherwise
+	}
+
+	branchTasks := l.tasks[thenStart:]
+	result := l.task("if", condition, then, otherwise)
+	// Tasks of nested conditionals keep their innermost gate
+	for idx, branchTask := range branchTasks {
+		if branchTask.Condition == "" {
+			branchTask.Condition = result.TaskID
+			branchTask.OnTrue = thenStart+idx < elseStart
+		}
+	}
+	return result
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Expression struct {
@@ -18,6 +23,22 @@ type Expression struct {
 	Expr   string
 	Status string
 	Result float64
+	// Error is a machine-readable failure reason for the "error" status
+	Error string
+	// Variables are the bindings the expression was submitted with
+	Variables map[string]float64
+	// Precision is empty for float64 arithmetic or precisionExact,
+	// ExactResult then holds the result without rounding
+	Precision   string
+	ExactResult string
+	// ImplicitMultiplication is the parser mode the expression was
+	// submitted with
+	ImplicitMultiplication bool
+	// CallbackURL receives the expression once it is finished,
+	// CallbackStatus and CallbackAttempts track the delivery
+	CallbackURL      string
+	CallbackStatus   string
+	CallbackAttempts []CallbackAttempt
 }
 
 type Task struct {
@@ -26,21 +47,68 @@ type Task struct {
 	Arg1          float64
 	Arg2          float64
 	Operation     string
-	OperationTime int      `json:"operation_time"`
-	Dependencies  []string `json:"-"`
-	Result        float64  `json:"-"`
-	Completed     bool     `json:"-"`
-	IsProcessing  bool     `json:"-"`
+	OperationTime int       `json:"operation_time"`
+	Precision     string    `json:"precision,omitempty"`
+	ExactArg1     string    `json:"exact_arg1,omitempty"`
+	ExactArg2     string    `json:"exact_arg2,omitempty"`
+	Operands      []Operand `json:"-"`
+	Result        float64   `json:"-"`
+	ExactResult   string    `json:"-"`
+	Completed     bool      `json:"-"`
+	IsProcessing  bool      `json:"-"`
+	// LeaseDeadline is the moment a dispatched task is considered lost
+	LeaseDeadline time.Time `json:"-"`
+	Attempts      int       `json:"-"`
+	// Condition is the "if" task that has to select the branch of a
+	// dormant task before it can run, OnTrue tells which branch it is
+	Condition string `json:"-"`
+	OnTrue    bool   `json:"-"`
+}
+
+// dependencies are the operands a task waits for. An unresolved "if"
+// only waits for its condition.
+func (t *Task) dependencies() []Operand {
+	if t.Operation == "if" && len(t.Operands) == 3 {
+		return t.Operands[:1]
+	}
+	return t.Operands
+}
+
+// Operand is a task argument: either a literal Value or the result
+// of the task referenced by TaskID. Literals of the exact mode also
+// carry their rational form in Exact.
+type Operand struct {
+	Value  float64
+	Exact  string
+	TaskID string
+}
+
+// EvalOptions are the per-request settings of an expression
+type EvalOptions struct {
+	Variables              map[string]float64 `json:"variables"`
+	Precision              string             `json:"precision"`
+	ImplicitMultiplication bool               `json:"implicit_multiplication"`
+	Optimization           string             `json:"optimization"`
 }
 
 var (
 	expressions            = make(map[string]*Expression)
 	tasks                  = make(map[string]*Task)
+	formulas               = make(map[string]*Formula)
 	mutex                  = &sync.Mutex{}
 	time_addition_ms       = getEnvAsInt("TIME_ADDITION_MS", 1000)
 	time_subtraction_ms    = getEnvAsInt("TIME_SUBTRACTION_MS", 1000)
 	time_multiplication_ms = getEnvAsInt("TIME_MULTIPLICATIONS_MS", 2000)
 	time_division_ms       = getEnvAsInt("TIME_DIVISIONS_MS", 2000)
+	time_negation_ms       = getEnvAsInt("TIME_NEGATION_MS", 1000)
+	time_modulo_ms         = getEnvAsInt("TIME_MODULO_MS", 2000)
+	time_exponentiation_ms = getEnvAsInt("TIME_EXPONENTIATION_MS", 2000)
+	time_function_ms       = getEnvAsInt("TIME_FUNCTIONS_MS", 1000)
+	time_comparison_ms     = getEnvAsInt("TIME_COMPARISONS_MS", 1000)
+	task_lease_grace_ms    = getEnvAsInt("TASK_LEASE_GRACE_MS", 5000)
+	task_max_attempts      = getEnvAsInt("TASK_MAX_ATTEMPTS", 3)
+	task_reaper_interval   = time.Duration(getEnvAsInt("TASK_REAPER_INTERVAL_MS", 1000)) * time.Millisecond
+	max_task_wait          = 60 * time.Second
 )
 
 func initListenAddress() string {
@@ -55,13 +123,40 @@ func initListenAddress() string {
 	return ipaddress_string + ":" + port_string
 }
 func main() {
+	storageType := flag.String("storage", "memory", "Storage backend: memory or bolt")
+	storagePath := flag.String("storage-path", "orchestrator.db", "Path to the bolt storage file")
+	grpcPort := flag.String("grpc-port", "9090", "Listen on port for gRPC agents, empty to disable")
+	listenAddress := initListenAddress()
+
+	var err error
+	store, err = openStorage(*storageType, *storagePath)
+	if err != nil {
+		log.Fatal("Error opening storage: ", err)
+	}
+	defer store.Close()
+
+	if err := loadState(); err != nil {
+		log.Fatal("Error loading storage: ", err)
+	}
 
 	http.HandleFunc("/api/v1/calculate", handleCalculate)
+	http.HandleFunc("/api/v1/calculate/batch", handleCalculateBatch)
 	http.HandleFunc("/api/v1/expressions", handleGetExpressions)
-	http.HandleFunc("/api/v1/expressions/", handleGetExpressionByID)
+	http.HandleFunc("/api/v1/expressions/", handleExpressionByID)
+	http.HandleFunc("/api/v1/events", handleEvents)
+	http.HandleFunc("/api/v1/ws", handleWebSocket)
+	http.HandleFunc("/api/v1/formulas", handleCreateFormula)
+	http.HandleFunc("/api/v1/formulas/", handleRunFormula)
 	http.HandleFunc("/internal/task", handleTask)
 
-	log.Fatal(http.ListenAndServe(initListenAddress(), nil))
+	go runTaskReaper(task_reaper_interval)
+
+	if *grpcPort != "" {
+		host, _, _ := net.SplitHostPort(listenAddress)
+		go serveGRPC(net.JoinHostPort(host, *grpcPort))
+	}
+
+	log.Fatal(http.ListenAndServe(listenAddress, nil))
 }
 
 func handleCalculate(w http.ResponseWriter, r *http.Request) {
@@ -69,40 +164,236 @@ func handleCalculate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request", http.StatusInternalServerError)
 		return
 	}
-	var req struct {
-		Expression string `json:"expression"`
+	wait, err := parseWait(r.URL.Query().Get("wait"))
+	if err != nil {
+		http.Error(w, "Invalid wait", http.StatusBadRequest)
+		return
 	}
+	var req CalculateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
 		return
 	}
 
-	req.Expression = strings.TrimSpace(req.Expression)
-	if req.Expression == "" {
-		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+	id := generateID()
+	var sub *subscription
+	if wait > 0 {
+		sub = events.subscribe(id)
+		defer events.unsubscribe(sub)
+	}
+
+	expr, err := calculate(id, req)
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	if wait <= 0 {
+		writeCreated(w, expr)
 		return
 	}
 
-	id := generateID()
+	waitForStatus(r.Context(), sub, wait)
+
+	mutex.Lock()
+	view := expressionView(expr)
+	finished := expr.Status != "pending"
+	mutex.Unlock()
+
+	if !finished {
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"expression": view})
+}
+
+// waitForStatus blocks until sub gets a status event, wait elapses, ctx
+// is done or the subscription is dropped
+func waitForStatus(ctx context.Context, sub *subscription, wait time.Duration) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.events:
+			if !ok || e.Name == eventStatus {
+				return
+			}
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CalculateRequest is the body of a calculate request
+type CalculateRequest struct {
+	Expression  string `json:"expression"`
+	CallbackURL string `json:"callback_url"`
+	EvalOptions
+}
+
+var (
+	errInvalidRequest = errors.New("Invalid request body")
+	errStorage        = errors.New("Internal server error")
+)
+
+// calculate validates and parses an expression and stores it under id.
+// It is the common part of every way to submit an expression.
+func calculate(id string, req CalculateRequest) (*Expression, error) {
+	prepared, err := prepareExpression(id, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := createExpression(prepared.expr, prepared.tasks, prepared.root); err != nil {
+		return nil, err
+	}
+	return prepared.expr, nil
+}
+
+// preparedExpression is a parsed expression ready to be stored
+type preparedExpression struct {
+	expr  *Expression
+	tasks []*Task
+	root  Operand
+}
+
+// prepareExpression validates and parses a calculate request, it needs
+// no lock
+func prepareExpression(id string, req CalculateRequest) (*preparedExpression, error) {
+	req.Expression = strings.TrimSpace(req.Expression)
+	if req.Expression == "" || !validPrecision(req.Precision) || !validOptimization(req.Optimization) ||
+		!validCallbackURL(req.CallbackURL) {
+		return nil, errInvalidRequest
+	}
+	if req.CallbackURL != "" && webhook_secret == "" {
+		return nil, errCallbacksDisabled
+	}
+
+	tasksForExpr, root, err := parseExpression(req.Expression, req.EvalOptions, id)
+	if err != nil {
+		return nil, err
+	}
+
+	expr := newExpression(id, req.Expression, req.EvalOptions)
+	expr.CallbackURL = req.CallbackURL
+	return &preparedExpression{expr: expr, tasks: tasksForExpr, root: root}, nil
+}
+
+func newExpression(id string, source string, options EvalOptions) *Expression {
 	expr := &Expression{
-		ID:     id,
-		Expr:   req.Expression,
-		Status: "pending",
+		ID:                     id,
+		Expr:                   source,
+		Status:                 "pending",
+		Variables:              options.Variables,
+		ImplicitMultiplication: options.ImplicitMultiplication,
+	}
+	if options.Precision == precisionExact {
+		expr.Precision = precisionExact
 	}
+	return expr
+}
 
+// createExpression stores a parsed expression with its tasks
+func createExpression(expr *Expression, tasksForExpr []*Task, root Operand) error {
 	mutex.Lock()
-	expressions[id] = expr
-	mutex.Unlock()
+	defer mutex.Unlock()
+	return insertExpression(expr, tasksForExpr, root)
+}
 
-	tasksForExpr := parseExpression(req.Expression, id)
-	for _, task := range tasksForExpr {
-		mutex.Lock()
-		tasks[task.ID] = task
-		mutex.Unlock()
+// insertExpression stores a parsed expression with its tasks, it
+// expects mutex to be held. An expression without tasks is completed
+// with the root literal right away. The created event comes before any
+// event of the tasks.
+func insertExpression(expr *Expression, tasksForExpr []*Task, root Operand) error {
+	settleRoot(expr, root)
+
+	// Tasks go first, a pending expression without tasks would never finish
+	if err := store.SaveTasks(tasksForExpr); err != nil {
+		log.Println("Error saving tasks:", err)
+		return errStorage
+	}
+	if err := store.SaveExpression(expr); err != nil {
+		log.Println("Error saving expression:", err)
+		return errStorage
 	}
 
+	registerExpression(expr, tasksForExpr)
+	return nil
+}
+
+// settleRoot completes an expression without tasks with its root literal
+func settleRoot(expr *Expression, root Operand) {
+	if root.TaskID == "" {
+		completeExpression(expr, root.Value, root.Exact)
+	}
+}
+
+// registerExpression puts a stored expression and its tasks into the
+// working set, it expects mutex to be held
+func registerExpression(expr *Expression, tasksForExpr []*Task) {
+	expressions[expr.ID] = expr
+	publishCreated(expr)
+	if expr.Status != "pending" {
+		expressionFinished(expr)
+	}
+	sched.add(tasksForExpr)
+}
+
+func writeCreated(w http.ResponseWriter, expr *Expression) {
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]string{"id": id})
+	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
+}
+
+// expressionErrorBody describes errors of an expression the client can
+// fix, nil for other errors
+func expressionErrorBody(err error) map[string]interface{} {
+	switch exprErr := err.(type) {
+	case *SyntaxError:
+		return map[string]interface{}{
+			"error":    exprErr.Error(),
+			"kind":     exprErr.Kind,
+			"token":    exprErr.Token,
+			"position": exprErr.Position,
+		}
+	case *UnboundVariablesError:
+		return map[string]interface{}{
+			"error": exprErr.Error(),
+			"kind":  "unbound_variable",
+			"names": exprErr.Names,
+		}
+	}
+	return nil
+}
+
+// requestErrorBody describes any error of calculate for replies that
+// are not plain HTTP responses
+func requestErrorBody(err error) map[string]interface{} {
+	if body := expressionErrorBody(err); body != nil {
+		return body
+	}
+	return map[string]interface{}{"error": err.Error()}
+}
+
+func writeExpressionError(w http.ResponseWriter, err error) {
+	if err == errStorage {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err == errCallbacksDisabled {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	body := expressionErrorBody(err)
+	if body == nil {
+		http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(body)
 }
 
 func handleGetExpressions(w http.ResponseWriter, r *http.Request) {
@@ -111,21 +402,70 @@ func handleGetExpressions(w http.ResponseWriter, r *http.Request) {
 
 	var exprs []map[string]interface{}
 	for _, expr := range expressions {
-		exprs = append(exprs, map[string]interface{}{
-			"id":     expr.ID,
-			"status": expr.Status,
-			"result": expr.Result,
-		})
+		exprs = append(exprs, expressionView(expr))
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{"expressions": exprs})
 }
 
+func handleExpressionByID(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(r.URL.Path[len("/api/v1/expressions/"):], "/")
+	switch {
+	case action == "ast":
+		handleGetExpressionAST(w, r, id)
+	case action == "events":
+		handleExpressionEvents(w, r, id)
+	case action != "":
+		http.Error(w, "Not found", http.StatusNotFound)
+	case r.Method == http.MethodDelete:
+		handleCancelExpression(w, r)
+	default:
+		handleGetExpressionByID(w, r)
+	}
+}
+
+// handleGetExpressionAST serves the syntax tree of an expression. The
+// tree is parsed again from the stored source.
+func handleGetExpressionAST(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request", http.StatusInternalServerError)
+		return
+	}
+
+	mutex.Lock()
+	expr, exists := expressions[id]
+	var source string
+	var implicitMultiplication bool
+	if exists {
+		source, implicitMultiplication = expr.Expr, expr.ImplicitMultiplication
+	}
+	mutex.Unlock()
+
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+
+	tree, err := parse(source, implicitMultiplication)
+	if err != nil {
+		log.Println("Error parsing stored expression:", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ast": tree})
+}
+
 func handleGetExpressionByID(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/api/v1/expressions/"):]
 
 	mutex.Lock()
 	expr, exists := expressions[id]
+	var view map[string]interface{}
+	if exists {
+		view = expressionView(expr)
+	}
 	mutex.Unlock()
 
 	if !exists {
@@ -133,248 +473,410 @@ func handleGetExpressionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"expression": map[string]interface{}{
-			"id":     expr.ID,
-			"status": expr.Status,
-			"result": expr.Result,
-		},
-	})
+	json.NewEncoder(w).Encode(map[string]interface{}{"expression": view})
+}
+
+func handleCancelExpression(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Path[len("/api/v1/expressions/"):]
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expr, exists := expressions[id]
+	if !exists {
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	if expr.Status != "pending" {
+		http.Error(w, "Expression is not pending", http.StatusConflict)
+		return
+	}
+
+	cancelExpression(expr)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"expression": expressionView(expr)})
+}
+
+// cancelExpression stops an expression, tasks already computed by agents
+// are dropped when their results arrive
+func cancelExpression(expr *Expression) {
+	expr.Status = "cancelled"
+	saveExpression(expr)
+	expressionFinished(expr)
+
+	sched.cancel(expr.ID)
+	if err := store.DeleteTasks(expr.ID); err != nil {
+		log.Println("Error deleting tasks:", err)
+	}
+}
+
+func expressionView(expr *Expression) map[string]interface{} {
+	view := map[string]interface{}{
+		"id":     expr.ID,
+		"status": expr.Status,
+		"result": expr.Result,
+	}
+	if expr.Error != "" {
+		view["error"] = expr.Error
+	}
+	if len(expr.Variables) > 0 {
+		view["variables"] = expr.Variables
+	}
+	if expr.Precision != "" {
+		view["precision"] = expr.Precision
+	}
+	if expr.ExactResult != "" {
+		view["exact_result"] = expr.ExactResult
+	}
+	if expr.CallbackURL != "" {
+		view["callback"] = map[string]interface{}{
+			"url":      expr.CallbackURL,
+			"status":   expr.CallbackStatus,
+			"attempts": expr.CallbackAttempts,
+		}
+	}
+	return view
+}
+
+// expressionFinished notifies the event streams and the callback of an
+// expression that reached its final status
+func expressionFinished(expr *Expression) {
+	notifyCallback(expr)
+	publishStatus(expr)
 }
 
 func handleTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		mutex.Lock()
-		for _, task := range tasks {
-			if task.IsProcessing {
-				continue
-			}
-			if !task.Completed && areDependenciesCompleted(task) {
-				json.NewEncoder(w).Encode(map[string]interface{}{"task": task})
-				task.IsProcessing = true
-				mutex.Unlock()
-				return
-			}
+		wait, err := parseWait(r.URL.Query().Get("wait"))
+		if err != nil {
+			http.Error(w, "Invalid wait", http.StatusBadRequest)
+			return
 		}
-		mutex.Unlock()
-		http.Error(w, "No tasks available", http.StatusNotFound)
+
+		task := dispatchTask(r.Context(), wait)
+		if task == nil {
+			http.Error(w, "No tasks available", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"task": task})
 	} else if r.Method == http.MethodPost {
 		var req struct {
-			ID     string  `json:"id"`
-			Result float64 `json:"result"`
+			ID          string  `json:"id"`
+			Result      float64 `json:"result"`
+			ExactResult string  `json:"exact_result"`
+			Error       string  `json:"error"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusUnprocessableEntity)
 			return
 		}
 
-		mutex.Lock()
-		defer mutex.Unlock()
-
-		task, exists := tasks[req.ID]
-		if !exists {
-			http.Error(w, "Task not found", http.StatusNotFound)
+		if err := submitResult(req.ID, req.Result, req.ExactResult, req.Error); err != nil {
+			status := http.StatusNotFound
+			switch err {
+			case errExpressionCancelled:
+				status = http.StatusConflict
+			case errInvalidResult:
+				status = http.StatusUnprocessableEntity
+			}
+			http.Error(w, err.Error(), status)
 			return
 		}
 
-		task.Result = req.Result
-		task.Completed = true
+		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		expr, exists := expressions[task.ExpressionID]
-		if !exists {
-			http.Error(w, "Expression not found", http.StatusNotFound)
-			return
-		}
+var (
+	errTaskNotFound        = errors.New("Task not found")
+	errExpressionNotFound  = errors.New("Expression not found")
+	errExpressionCancelled = errors.New("Expression cancelled")
+	errInvalidResult       = errors.New("Invalid exact result")
+)
 
-		if isFinalTask(task.ExpressionID) {
-			expr.Result = task.Result
-			expr.Status = "completed"
-			clearExpressionTasks(task.ExpressionID)
-		}
+// submitResult records the result of a task computed by an agent, exact
+// is the rational result of the exact mode. A non-empty reason fails
+// the whole expression instead.
+func submitResult(taskID string, result float64, exact string, reason string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
 
-		w.WriteHeader(http.StatusOK)
+	task, exists := tasks[taskID]
+	if !exists {
+		return errTaskNotFound
 	}
-}
 
-func clearExpressionTasks(expressionID string) {
-	for id, task := range tasks {
-		if task.ExpressionID == expressionID {
-			delete(tasks, id)
+	expr, exists := expressions[task.ExpressionID]
+	if !exists {
+		return errExpressionNotFound
+	}
+
+	if expr.Status == "cancelled" {
+		sched.release(task)
+		return errExpressionCancelled
+	}
+
+	if reason != "" {
+		failExpression(expr.ID, reason)
+		return nil
+	}
+
+	task.Result = result
+	if task.Precision == precisionExact {
+		value, ok := new(big.Rat).SetString(exact)
+		if !ok {
+			return errInvalidResult
 		}
+		task.Result = approximate(value)
+		task.ExactResult = value.RatString()
 	}
+
+	completeTask(expr, task)
+	return nil
 }
 
-func areDependenciesCompleted(task *Task) bool {
-	for idx, depID := range task.Dependencies {
-		depTask, exists := tasks[depID]
-		if !exists || !depTask.Completed {
-			return false
-		}
-		updateTaskByDependency(task, idx, depTask.Result)
+// completeTask marks a task with its result completed and settles the
+// conditionals it unblocks. The last task completes the expression.
+func completeTask(expr *Expression, task *Task) {
+	if !task.Completed {
+		publishTaskCompleted(task)
 	}
-	return true
+	if sched.complete(task) {
+		completeExpression(expr, task.Result, task.ExactResult)
+		saveExpression(expr)
+		expressionFinished(expr)
+		clearExpressionTasks(task.ExpressionID)
+		return
+	}
+	saveTask(task)
+	resolveConditions()
 }
 
-func updateTaskByDependency(task *Task, index int, value float64) {
-	depsCount := len(task.Dependencies)
-	if depsCount == 1 {
-		if task.Arg1 == 0 && task.Arg2 != 0 {
-			task.Arg1 = value
-		} else {
-			task.Arg2 = value
+// resolveConditions settles "if" tasks queued by the scheduler. Once
+// the condition is known the selected branch is activated and the other
+// one dropped, then the "if" task waits for the branch result and
+// forwards it.
+func resolveConditions() {
+	for len(sched.conditions) > 0 {
+		task, exists := tasks[sched.conditions[0]]
+		sched.conditions = sched.conditions[1:]
+		if !exists || task.Completed {
+			continue
 		}
-	} else if depsCount == 2 {
-		if index == 0 {
-			task.Arg1 = value
-		} else {
-			if task.Arg1 == 0 && task.Arg2 != 0 {
-				task.Arg1 = value
-			} else {
-				task.Arg2 = value
+
+		if len(task.Operands) == 1 {
+			task.Result, task.ExactResult = operandValue(task.Operands[0])
+			completeTask(expressions[task.ExpressionID], task)
+			continue
+		}
+
+		onTrue := isTruthy(operandValue(task.Operands[0]))
+		activated, dropped := sched.selectBranch(task, onTrue)
+		for _, branchTask := range activated {
+			saveTask(branchTask)
+		}
+		for _, branchTask := range dropped {
+			if err := store.DeleteTask(branchTask); err != nil {
+				log.Println("Error deleting task:", err)
 			}
 		}
-	}
-}
 
-func isFinalTask(expressionID string) bool {
-	for _, task := range tasks {
-		if task.ExpressionID == expressionID && !task.Completed {
-			return false
+		if onTrue {
+			task.Operands = task.Operands[1:2]
+		} else {
+			task.Operands = task.Operands[2:]
 		}
+		saveTask(task)
+		sched.schedule(task)
 	}
-	return true
 }
 
-func generateID() (uuid string) {
+// operandValue returns the value of a literal or of a completed task
+func operandValue(operand Operand) (float64, string) {
+	if operand.TaskID != "" {
+		return tasks[operand.TaskID].Result, tasks[operand.TaskID].ExactResult
+	}
+	return operand.Value, operand.Exact
+}
 
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil {
-		fmt.Println("Error: ", err)
-		return
+// isTruthy treats any non-zero value as true
+func isTruthy(value float64, exact string) bool {
+	if exact != "" {
+		rat, _ := new(big.Rat).SetString(exact)
+		return rat.Sign() != 0
 	}
+	return value != 0
+}
 
-	uuid = fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+// completeExpression stores the final result, exact is empty unless the
+// expression is in the exact mode
+func completeExpression(expr *Expression, result float64, exact string) {
+	expr.Status = "completed"
+	expr.Result = result
+	if exact != "" {
+		value, _ := new(big.Rat).SetString(exact)
+		expr.ExactResult = formatExact(value)
+	}
+}
 
-	return
+// parseWait parses the wait parameter of a long polling request, the
+// wait is capped at max_task_wait
+func parseWait(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := time.ParseDuration(value)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait %q", value)
+	}
+	return min(wait, max_task_wait), nil
 }
 
-func parseExpression(expr string, expressionID string) []*Task {
-	postfix := infixToPostfix(expr)
-	stack := []*Task{}
-	tasks := []*Task{}
+// dispatchTask leases the next ready task to an agent. When nothing is
+// ready it blocks until a task gets queued, wait elapses or ctx is done,
+// then returns nil. The returned task is a snapshot safe to use without
+// holding mutex.
+func dispatchTask(ctx context.Context, wait time.Duration) *Task {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
 
-	for _, token := range postfix {
-		switch token {
-		case "+", "-", "*", "/":
-			arg2Task := stack[len(stack)-1]
-			arg1Task := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
+	for {
+		if task := sched.next(); task != nil {
+			resolveOperands(task)
+			task.IsProcessing = true
+			task.Attempts++
+			task.LeaseDeadline = time.Now().Add(leaseDuration(task))
+			sched.lease(task)
+			publishTaskDispatched(task)
+
+			dispatched := *task
+			return &dispatched
+		}
+		if wait <= 0 {
+			return nil
+		}
 
-			deps := []string{}
-			if !arg1Task.Completed {
-				deps = append(deps, arg1Task.ID)
-			}
-			if !arg2Task.Completed {
-				deps = append(deps, arg2Task.ID)
-			}
-			task := &Task{
-				ID:            generateID(),
-				ExpressionID:  expressionID,
-				Arg1:          arg1Task.Result,
-				Arg2:          arg2Task.Result,
-				Operation:     token,
-				OperationTime: getOperationTime(token),
-				Dependencies:  deps,
-			}
-			tasks = append(tasks, task)
-
-			stack = append(stack, task)
-		default:
-			num, _ := strconv.ParseFloat(token, 64)
-			task := &Task{
-				ID:            generateID(),
-				ExpressionID:  expressionID,
-				Arg1:          num,
-				Arg2:          0,
-				Operation:     "",
-				OperationTime: 0,
-				Dependencies:  []string{},
-				Result:        num,
-				Completed:     true,
-			}
-			stack = append(stack, task)
+		ready := sched.readyChan()
+		mutex.Unlock()
+		select {
+		case <-ready:
+			mutex.Lock()
+		case <-timer.C:
+			mutex.Lock()
+			return nil
+		case <-ctx.Done():
+			mutex.Lock()
+			return nil
 		}
 	}
+}
 
-	return tasks
+func leaseDuration(task *Task) time.Duration {
+	return time.Duration(task.OperationTime+task_lease_grace_ms) * time.Millisecond
 }
 
-func infixToPostfix(expr string) []string {
-	var output []string
-	var stack []string
+func runTaskReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	precedence := map[string]int{
-		"+": 1,
-		"-": 1,
-		"*": 2,
-		"/": 2,
+	for now := range ticker.C {
+		mutex.Lock()
+		reapExpiredTasks(now)
+		mutex.Unlock()
 	}
+}
 
-	tokens := tokenize(expr)
-	for _, token := range tokens {
-		switch token {
-		case "+", "-", "*", "/":
-			for len(stack) > 0 && precedence[stack[len(stack)-1]] >= precedence[token] {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, token)
-		case "(":
-			stack = append(stack, token)
-		case ")":
-			for len(stack) > 0 && stack[len(stack)-1] != "(" {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = stack[:len(stack)-1]
-		default:
-			output = append(output, token)
+// reapExpiredTasks returns tasks with an expired lease to the ready pool.
+// An expression whose task has run out of attempts is failed.
+func reapExpiredTasks(now time.Time) {
+	for _, task := range sched.leased {
+		if now.Before(task.LeaseDeadline) {
+			continue
 		}
+
+		// The result of a cancelled expression is not awaited anymore
+		if sched.cancelled[task.ExpressionID] {
+			sched.release(task)
+			continue
+		}
+
+		if task.Attempts < task_max_attempts {
+			log.Printf("Task %s lease expired, re-queueing (attempt %d of %d)", task.ID, task.Attempts, task_max_attempts)
+			sched.requeue(task)
+			continue
+		}
+
+		log.Printf("Task %s lease expired after %d attempts, failing expression %s", task.ID, task.Attempts, task.ExpressionID)
+		failExpression(task.ExpressionID, "task_timeout")
 	}
+}
 
-	for len(stack) > 0 {
-		output = append(output, stack[len(stack)-1])
-		stack = stack[:len(stack)-1]
+// failExpression marks expression as failed with reason and cancels
+// its remaining tasks. An expression that is not pending anymore keeps
+// its final status.
+func failExpression(expressionID string, reason string) {
+	expr, exists := expressions[expressionID]
+	if exists && expr.Status != "pending" {
+		return
+	}
+	if exists {
+		expr.Status = "error"
+		expr.Error = reason
+		saveExpression(expr)
+		expressionFinished(expr)
 	}
+	clearExpressionTasks(expressionID)
+}
 
-	return output
+func saveExpression(expr *Expression) {
+	if err := store.SaveExpression(expr); err != nil {
+		log.Println("Error saving expression:", err)
+	}
 }
 
-func tokenize(expr string) []string {
-	var tokens []string
-	var currentToken string
+func saveTask(task *Task) {
+	if err := store.SaveTasks([]*Task{task}); err != nil {
+		log.Println("Error saving task:", err)
+	}
+}
 
-	for _, char := range expr {
-		if char == ' ' {
-			continue
-		}
-		if char == '+' || char == '-' || char == '*' || char == '/' || char == '(' || char == ')' {
-			if currentToken != "" {
-				tokens = append(tokens, currentToken)
-				currentToken = ""
-			}
-			tokens = append(tokens, string(char))
-		} else {
-			currentToken += string(char)
+func clearExpressionTasks(expressionID string) {
+	sched.remove(expressionID)
+	if err := store.DeleteTasks(expressionID); err != nil {
+		log.Println("Error deleting tasks:", err)
+	}
+}
+
+// resolveOperands fills task arguments from its operand slots,
+// the first operand always goes to Arg1 and the second to Arg2.
+func resolveOperands(task *Task) {
+	args := []*float64{&task.Arg1, &task.Arg2}
+	exactArgs := []*string{&task.ExactArg1, &task.ExactArg2}
+	for idx, operand := range task.Operands {
+		value, exact := operand.Value, operand.Exact
+		if operand.TaskID != "" {
+			value, exact = tasks[operand.TaskID].Result, tasks[operand.TaskID].ExactResult
 		}
+		*args[idx] = value
+		*exactArgs[idx] = exact
 	}
+}
+
+func generateID() (uuid string) {
 
-	if currentToken != "" {
-		tokens = append(tokens, currentToken)
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return
 	}
 
-	return tokens
+	uuid = fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+
+	return
 }
 
 func getOperationTime(operation string) int {
@@ -387,7 +889,21 @@ func getOperationTime(operation string) int {
 		return time_multiplication_ms
 	case "/":
 		return time_division_ms
+	case "%":
+		return time_modulo_ms
+	case "^":
+		return time_exponentiation_ms
+	case "neg":
+		return time_negation_ms
+	case "==", "!=", "<", "<=", ">", ">=", "&&", "||", "!":
+		return time_comparison_ms
+	case "if":
+		// Resolved by the orchestrator
+		return 0
 	default:
+		if _, exists := functions[operation]; exists {
+			return time_function_ms
+		}
 		return 1000
 	}
 }
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"math"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Helper function to create a test request
@@ -19,6 +23,8 @@ func createTestRequest(method, path string, body string) *http.Request {
 func setupTest() {
 	expressions = make(map[string]*Expression)
 	tasks = make(map[string]*Task)
+	formulas = make(map[string]*Formula)
+	sched = newScheduler()
 }
 
 func TestHandleCalculate(t *testing.T) {
@@ -48,6 +54,12 @@ func TestHandleCalculate(t *testing.T) {
 			expectedStatus: http.StatusUnprocessableEntity,
 			expectedBody:   false,
 		},
+		{
+			name:           "Malformed expression",
+			requestBody:    `{"expression": "2 * * 3"}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedBody:   false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -76,6 +88,164 @@ func TestHandleCalculate(t *testing.T) {
 	}
 }
 
+func TestHandleCalculateWait(t *testing.T) {
+	setupTest()
+
+	// An agent computing tasks in the background
+	ctx, cancel := context.WithCancel(t.Context())
+	agentDone := make(chan struct{})
+	go func() {
+		defer close(agentDone)
+		for {
+			task := dispatchTask(ctx, time.Second)
+			if task == nil {
+				return
+			}
+			submitResult(task.ID, testOperations[task.Operation](task.Arg1, task.Arg2), "", "")
+		}
+	}()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate?wait=5s", `{"expression": "2 + 3 * 4"}`))
+	cancel()
+	<-agentDone
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var response struct {
+		Expression map[string]interface{}
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if response.Expression["status"] != "completed" || response.Expression["result"] != float64(14) {
+		t.Errorf("Expected completed expression with result 14, got %v", response.Expression)
+	}
+}
+
+func TestHandleCalculateWaitTimeout(t *testing.T) {
+	setupTest()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate?wait=20ms", `{"expression": "2 + 3"}`))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusAccepted)
+	}
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	if expr, exists := expressions[created["id"]]; !exists || expr.Status != "pending" {
+		t.Errorf("Expected the pending expression %q to be stored", created["id"])
+	}
+
+	// Constant expressions need no agents
+	rr = httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate?wait=1s", `{"expression": "max(7)"}`))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"completed"`) {
+		t.Errorf("Expected the completed expression, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate?wait=soon", `{"expression": "2 + 3"}`))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleCalculateSyntaxError(t *testing.T) {
+	setupTest()
+
+	req := createTestRequest("POST", "/api/v1/calculate", `{"expression": "(2+3"}`)
+	rr := httptest.NewRecorder()
+
+	handleCalculate(rr, req)
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if response["kind"] != ErrUnclosedParenthesis {
+		t.Errorf("Expected kind %s, got %v", ErrUnclosedParenthesis, response["kind"])
+	}
+	if response["token"] != "(" {
+		t.Errorf("Expected token (, got %v", response["token"])
+	}
+	if response["position"] != float64(0) {
+		t.Errorf("Expected position 0, got %v", response["position"])
+	}
+	if len(expressions) != 0 {
+		t.Errorf("Expected no expressions to be stored, got %d", len(expressions))
+	}
+}
+
+func TestHandleCalculateUnboundVariables(t *testing.T) {
+	setupTest()
+
+	body := `{"expression": "a * x + b * x + c", "variables": {"a": 2}}`
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", body))
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+
+	var response struct {
+		Kind  string
+		Names []string
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if response.Kind != "unbound_variable" {
+		t.Errorf("Expected kind unbound_variable, got %v", response.Kind)
+	}
+	if strings.Join(response.Names, " ") != "x b c" {
+		t.Errorf("Expected names [x b c], got %v", response.Names)
+	}
+	if len(expressions) != 0 {
+		t.Errorf("Expected no expressions to be stored, got %d", len(expressions))
+	}
+}
+
+func TestEvaluateVariables(t *testing.T) {
+	setupTest()
+
+	body := `{"expression": "a * x + b", "variables": {"a": 2, "x": 3, "b": -1, "unused": 5}}`
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", body))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var created map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	expr := finishExpression(t, created["id"])
+	if expr.Status != "completed" || expr.Result != 5 {
+		t.Errorf("Expected completed with result 5, got %s %v", expr.Status, expr.Result)
+	}
+	if len(expr.Variables) != 4 {
+		t.Errorf("Expected bindings to be kept, got %v", expr.Variables)
+	}
+
+	// neg is an ordinary name, unary minus is not spelled that way
+	expr, err := calculate("neg", CalculateRequest{Expression: "neg + 1", EvalOptions: EvalOptions{Variables: map[string]float64{"neg": 1}}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expr := finishExpression(t, expr.ID); expr.Status != "completed" || expr.Result != 2 {
+		t.Errorf("Expected completed with result 2, got %s %v", expr.Status, expr.Result)
+	}
+}
+
 func TestHandleGetExpressions(t *testing.T) {
 	setupTest()
 
@@ -173,6 +343,44 @@ func TestHandleGetExpressionByID(t *testing.T) {
 	}
 }
 
+func TestHandleGetExpressionAST(t *testing.T) {
+	setupTest()
+
+	expressions["test1"] = &Expression{
+		ID:                     "test1",
+		Expr:                   "2x + max(1, -y)",
+		Status:                 "pending",
+		ImplicitMultiplication: true,
+	}
+
+	rr := httptest.NewRecorder()
+	handleExpressionByID(rr, createTestRequest("GET", "/api/v1/expressions/test1/ast", ""))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	expected := `{"ast":{"left":{"left":{"position":0,"type":"number","value":"2"},` +
+		`"operator":"*","position":1,"right":{"name":"x","position":1,"type":"variable"},"type":"binary"},` +
+		`"operator":"+","position":3,"right":{"arguments":[{"position":9,"type":"number","value":"1"},` +
+		`{"operand":{"name":"y","position":13,"type":"variable"},"operator":"-","position":12,"type":"unary"}],` +
+		`"function":"max","position":5,"type":"call"},"type":"binary"}}`
+	if body := strings.TrimSpace(rr.Body.String()); body != expected {
+		t.Errorf("Unexpected AST:\n got %s\nwant %s", body, expected)
+	}
+
+	for path, status := range map[string]int{
+		"/api/v1/expressions/nonexistent/ast": http.StatusNotFound,
+		"/api/v1/expressions/test1/tree":      http.StatusNotFound,
+	} {
+		rr := httptest.NewRecorder()
+		handleExpressionByID(rr, createTestRequest("GET", path, ""))
+		if rr.Code != status {
+			t.Errorf("GET %s returned %v, want %v", path, rr.Code, status)
+		}
+	}
+}
+
 func TestHandleTask(t *testing.T) {
 	setupTest()
 
@@ -184,11 +392,11 @@ func TestHandleTask(t *testing.T) {
 		Arg2:          3,
 		Operation:     "+",
 		OperationTime: 1000,
-		Dependencies:  []string{},
+		Operands:      []Operand{{Value: 2}, {Value: 3}},
 		Completed:     false,
 		IsProcessing:  false,
 	}
-	tasks["task1"] = testTask
+	sched.add([]*Task{testTask})
 
 	expressions["expr1"] = &Expression{
 		ID:     "expr1",
@@ -270,11 +478,34 @@ func TestParseExpression(t *testing.T) {
 			expression:    "(2 + 3) * 4",
 			expectedTasks: 2,
 		},
+		{
+			name:          "Signed literal",
+			expression:    "-3 + 5",
+			expectedTasks: 1,
+		},
+		{
+			name:          "Signed literal after operator",
+			expression:    "2 * -4",
+			expectedTasks: 1,
+		},
+		{
+			name:          "Negated parentheses",
+			expression:    "-(1 + 2)",
+			expectedTasks: 2,
+		},
+		{
+			name:          "Function calls",
+			expression:    "sqrt(2) * max(3, 4, 5)",
+			expectedTasks: 4,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tasks := parseExpression(tt.expression, "test-expr-id")
+			tasks, _, err := parseExpression(tt.expression, EvalOptions{}, "test-expr-id")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
 			if len(tasks) != tt.expectedTasks {
 				t.Errorf("Expected %d tasks, got %d", tt.expectedTasks, len(tasks))
 			}
@@ -282,41 +513,608 @@ func TestParseExpression(t *testing.T) {
 	}
 }
 
-func TestTokenize(t *testing.T) {
+func TestParseExpressionNegation(t *testing.T) {
+	tasks, _, err := parseExpression("-(1 + 2)", EvalOptions{}, "test-expr-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(tasks))
+	}
+
+	neg := tasks[1]
+	if neg.Operation != "neg" {
+		t.Fatalf("Expected neg operation, got %s", neg.Operation)
+	}
+	if len(neg.Operands) != 1 || neg.Operands[0].TaskID != tasks[0].ID {
+		t.Errorf("Expected neg to depend on %s, got %v", tasks[0].ID, neg.Operands)
+	}
+
+	literal, _, err := parseExpression("2 * -4", EvalOptions{}, "test-expr-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Operand{{Value: 2}, {Value: -4}}
+	if len(literal[0].Operands) != 2 || literal[0].Operands[0] != expected[0] || literal[0].Operands[1] != expected[1] {
+		t.Errorf("Expected operands %v, got %v", expected, literal[0].Operands)
+	}
+}
+
+func TestParseExpressionOutOfRange(t *testing.T) {
+	// 2^1024 is the first power of two float64 cannot hold
+	beyondFloat := new(big.Int).Lsh(big.NewInt(1), 1024)
 	tests := []struct {
-		name     string
-		expr     string
-		expected []string
+		expression string
+		options    EvalOptions
+		token      string
+		position   int
+	}{
+		{"2 * -1e400", EvalOptions{}, "1e400", 5},
+		{"min(1e400, 1)", EvalOptions{Optimization: optimizationFold}, "1e400", 4},
+		{"1 + 0x" + beyondFloat.Text(16), EvalOptions{}, beyondFloat.String(), 4},
+	}
+
+	for _, tt := range tests {
+		_, _, err := parseExpression(tt.expression, tt.options, "test-expr-id")
+		syntaxErr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("%.20s: expected *SyntaxError, got %v", tt.expression, err)
+			continue
+		}
+		if syntaxErr.Kind != ErrNumberOutOfRange || syntaxErr.Token != tt.token || syntaxErr.Position != tt.position {
+			t.Errorf("%.20s: got %s at position %d, want %s at position %d",
+				tt.expression, syntaxErr.Kind, syntaxErr.Position, ErrNumberOutOfRange, tt.position)
+		}
+	}
+
+	// The exact mode holds any literal
+	options := EvalOptions{Precision: precisionExact}
+	if _, _, err := parseExpression("2 * -1e400", options, "test-expr-id"); err != nil {
+		t.Errorf("Unexpected error in the exact mode: %v", err)
+	}
+}
+
+func TestHandleTaskLease(t *testing.T) {
+	setupTest()
+
+	sched.add([]*Task{{
+		ID:            "task1",
+		ExpressionID:  "expr1",
+		Arg1:          2,
+		Arg2:          3,
+		Operation:     "+",
+		OperationTime: 1000,
+		Operands:      []Operand{{Value: 2}, {Value: 3}},
+	}})
+
+	before := time.Now()
+	rr := httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	task := tasks["task1"]
+	if task.Attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", task.Attempts)
+	}
+	if task.LeaseDeadline.Before(before.Add(leaseDuration(task))) {
+		t.Errorf("Expected lease deadline after %v, got %v", before.Add(leaseDuration(task)), task.LeaseDeadline)
+	}
+
+	// A leased task is not handed out twice
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestReapExpiredTasks(t *testing.T) {
+	setupTest()
+
+	now := time.Now()
+	expressions["expr1"] = &Expression{ID: "expr1", Status: "pending"}
+	expressions["expr2"] = &Expression{ID: "expr2", Status: "pending"}
+	sched.add([]*Task{{
+		ID:            "expired",
+		ExpressionID:  "expr1",
+		IsProcessing:  true,
+		Attempts:      1,
+		LeaseDeadline: now.Add(-time.Second),
+	}, {
+		ID:            "leased",
+		ExpressionID:  "expr1",
+		IsProcessing:  true,
+		Attempts:      1,
+		LeaseDeadline: now.Add(time.Second),
+	}, {
+		ID:            "exhausted",
+		ExpressionID:  "expr2",
+		IsProcessing:  true,
+		Attempts:      task_max_attempts,
+		LeaseDeadline: now.Add(-time.Second),
+	}})
+
+	reapExpiredTasks(now)
+
+	if task := sched.next(); task == nil || task.ID != "expired" {
+		t.Errorf("Expected expired task to be returned to the ready pool, got %v", task)
+	}
+	if !tasks["leased"].IsProcessing {
+		t.Error("Expected task with a valid lease to stay in processing")
+	}
+	if expressions["expr1"].Status != "pending" {
+		t.Errorf("Expected expr1 to stay pending, got %s", expressions["expr1"].Status)
+	}
+	if _, exists := tasks["exhausted"]; exists {
+		t.Error("Expected tasks of a failed expression to be removed")
+	}
+	if expressions["expr2"].Status != "error" {
+		t.Errorf("Expected expr2 status error, got %s", expressions["expr2"].Status)
+	}
+	if expressions["expr2"].Error != "task_timeout" {
+		t.Errorf("Expected expr2 error task_timeout, got %s", expressions["expr2"].Error)
+	}
+}
+
+func TestHandleTaskError(t *testing.T) {
+	setupTest()
+
+	expressions["expr1"] = &Expression{ID: "expr1", Expr: "1 / 0 + 2 * 3", Status: "pending"}
+	sched.add([]*Task{
+		{ID: "div", ExpressionID: "expr1", Arg1: 1, Arg2: 0, Operation: "/", IsProcessing: true},
+		{ID: "mul", ExpressionID: "expr1", Arg1: 2, Arg2: 3, Operation: "*"},
+		{ID: "add", ExpressionID: "expr1", Operation: "+", Operands: []Operand{{TaskID: "div"}, {TaskID: "mul"}}},
+	})
+
+	rr := httptest.NewRecorder()
+	handleTask(rr, createTestRequest("POST", "/internal/task", `{"id": "div", "error": "division_by_zero"}`))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	if len(tasks) != 0 {
+		t.Errorf("Expected remaining tasks to be cancelled, got %d", len(tasks))
+	}
+
+	rr = httptest.NewRecorder()
+	handleGetExpressionByID(rr, createTestRequest("GET", "/api/v1/expressions/expr1", ""))
+
+	var response map[string]map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+	if status := response["expression"]["status"]; status != "error" {
+		t.Errorf("Expected status error, got %v", status)
+	}
+	if reason := response["expression"]["error"]; reason != "division_by_zero" {
+		t.Errorf("Expected error division_by_zero, got %v", reason)
+	}
+}
+
+// testOperations stand in for the agent when evaluating expressions
+var testOperations = map[string]func(arg1, arg2 float64) float64{
+	"+":     func(a, b float64) float64 { return a + b },
+	"-":     func(a, b float64) float64 { return a - b },
+	"*":     func(a, b float64) float64 { return a * b },
+	"/":     func(a, b float64) float64 { return a / b },
+	"%":     math.Mod,
+	"^":     math.Pow,
+	"neg":   func(a, _ float64) float64 { return -a },
+	"abs":   func(a, _ float64) float64 { return math.Abs(a) },
+	"sqrt":  func(a, _ float64) float64 { return math.Sqrt(a) },
+	"log":   func(a, _ float64) float64 { return math.Log10(a) },
+	"floor": func(a, _ float64) float64 { return math.Floor(a) },
+	"ceil":  func(a, _ float64) float64 { return math.Ceil(a) },
+	"round": func(a, _ float64) float64 { return math.Round(a) },
+	"min":   math.Min,
+	"max":   math.Max,
+	"<":     func(a, b float64) float64 { return testBool(a < b) },
+	">":     func(a, b float64) float64 { return testBool(a > b) },
+	"==":    func(a, b float64) float64 { return testBool(a == b) },
+	"&&":    func(a, b float64) float64 { return testBool(a != 0 && b != 0) },
+	"!":     func(a, _ float64) float64 { return testBool(a == 0) },
+}
+
+func testBool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// testExactOperations stand in for the agent in the exact mode
+var testExactOperations = map[string]func(result, arg1, arg2 *big.Rat) *big.Rat{
+	"+":   (*big.Rat).Add,
+	"-":   (*big.Rat).Sub,
+	"*":   (*big.Rat).Mul,
+	"/":   (*big.Rat).Quo,
+	"neg": func(result, arg1, _ *big.Rat) *big.Rat { return result.Neg(arg1) },
+	"==":  func(result, arg1, arg2 *big.Rat) *big.Rat { return result.SetFloat64(testBool(arg1.Cmp(arg2) == 0)) },
+}
+
+// evaluate submits expr and computes it to the end
+func evaluate(t *testing.T, expr string) *Expression {
+	t.Helper()
+	return evaluateRequest(t, map[string]interface{}{"expression": expr})
+}
+
+// evaluateRequest submits a calculate request body and computes the
+// expression to the end
+func evaluateRequest(t *testing.T, request map[string]interface{}) *Expression {
+	t.Helper()
+
+	body, _ := json.Marshal(request)
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", string(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var created map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response body: %v", err)
+	}
+
+	return finishExpression(t, created["id"])
+}
+
+// finishExpression plays the agent role until the expression leaves
+// the pending status
+func finishExpression(t *testing.T, id string) *Expression {
+	t.Helper()
+
+	for {
+		expr := expressions[id]
+		if expr.Status != "pending" {
+			return expr
+		}
+
+		rr := httptest.NewRecorder()
+		handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("No task available for pending expression %q", expr.Expr)
+		}
+
+		var response struct {
+			Task struct {
+				ID        string
+				Arg1      float64
+				Arg2      float64
+				Operation string
+				Precision string `json:"precision"`
+				ExactArg1 string `json:"exact_arg1"`
+				ExactArg2 string `json:"exact_arg2"`
+			}
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response body: %v", err)
+		}
+
+		var body []byte
+		if response.Task.Precision == precisionExact {
+			compute, exists := testExactOperations[response.Task.Operation]
+			if !exists {
+				t.Fatalf("Unexpected exact operation %q", response.Task.Operation)
+			}
+			arg1, _ := new(big.Rat).SetString(response.Task.ExactArg1)
+			arg2, ok := new(big.Rat).SetString(response.Task.ExactArg2)
+			if !ok {
+				arg2 = new(big.Rat)
+			}
+			result := compute(new(big.Rat), arg1, arg2)
+			body, _ = json.Marshal(map[string]interface{}{"id": response.Task.ID, "exact_result": result.RatString()})
+		} else {
+			compute, exists := testOperations[response.Task.Operation]
+			if !exists {
+				t.Fatalf("Unexpected operation %q", response.Task.Operation)
+			}
+			result := compute(response.Task.Arg1, response.Task.Arg2)
+			body, _ = json.Marshal(map[string]interface{}{"id": response.Task.ID, "result": result})
+		}
+		rr = httptest.NewRecorder()
+		handleTask(rr, createTestRequest("POST", "/internal/task", string(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestEvaluatePowerAndModulo(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"(-2) ^ 2", 4},
+		{"2 ^ -1", 0.5},
+		{"2 * 3 ^ 2", 18},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 * 7 % 4", 2},
+		{"1 + 2 ^ (1 + 1) % 3", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluate(t, tt.expression)
+			if expr.Status != "completed" {
+				t.Fatalf("Expected status completed, got %s", expr.Status)
+			}
+			if expr.Result != tt.expected {
+				t.Errorf("%s = %v, want %v", tt.expression, expr.Result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluateFunctions(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"sqrt(16) * max(3, 4, 5)", 20},
+		{"abs(-3) + min(2, -1)", 2},
+		{"max(7)", 7},
+		{"min(max(1, 2), 3 - 2, 5)", 1},
+		{"round(2.6) - floor(2.6) + ceil(2.1)", 4},
+		{"-sqrt(4) ^ 2", -4},
+		{"log(10 ^ 3)", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluate(t, tt.expression)
+			if expr.Status != "completed" {
+				t.Fatalf("Expected status completed, got %s", expr.Status)
+			}
+			if expr.Result != tt.expected {
+				t.Errorf("%s = %v, want %v", tt.expression, expr.Result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluateImplicitMultiplication(t *testing.T) {
+	setupTest()
+
+	expr := evaluateRequest(t, map[string]interface{}{
+		"expression":              "(1+2)(3+4) - 2x",
+		"variables":               map[string]float64{"x": 5},
+		"implicit_multiplication": true,
+	})
+	if expr.Status != "completed" || expr.Result != 11 {
+		t.Errorf("Expected completed with result 11, got %s %v", expr.Status, expr.Result)
+	}
+}
+
+func TestEvaluateConstant(t *testing.T) {
+	for expression, expected := range map[string]float64{"7": 7, "-(2.5)": -2.5, "-0b1010": -10, "1_000.5e-3": 1.0005} {
+		setupTest()
+
+		expr := evaluate(t, expression)
+		if expr.Status != "completed" || expr.Result != expected {
+			t.Errorf("%s: got status %s result %v, want completed %v", expression, expr.Status, expr.Result, expected)
+		}
+		if len(tasks) != 0 {
+			t.Errorf("%s: expected no tasks, got %d", expression, len(tasks))
+		}
+	}
+}
+
+func TestEvaluateZeroOperands(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"0 - (2*3)", -6},
+		{"(1-1) / (2+2)", 0},
+		{"(2*3) - 0", 6},
+		{"0 * (1+2)", 0},
+		{"(1+2) * 0", 0},
+		{"(0+0) - 5", -5},
+		{"5 - (0*3)", 5},
+		{"(1-1) - (2*3)", -6},
+		{"(2*3) - (1-1)", 6},
+		{"(3-3) - (2-2)", 0},
+		{"0 - -(1+1)", 2},
+		{"-(1-1) - 4", -4},
+		{"0 / (2+2) - (3-3)", 0},
+		{"2 + 3 * 4", 14},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluate(t, tt.expression)
+			if expr.Status != "completed" {
+				t.Fatalf("Expected status completed, got %s", expr.Status)
+			}
+			if expr.Result != tt.expected {
+				t.Errorf("%s = %v, want %v", tt.expression, expr.Result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHandleTaskLongPolling(t *testing.T) {
+	setupTest()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		handleTask(rr, createTestRequest("GET", "/internal/task?wait=5s", ""))
+		done <- rr
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	handleCalculate(httptest.NewRecorder(), createTestRequest("POST", "/api/v1/calculate", `{"expression": "2 + 3"}`))
+
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected task to be dispatched as soon as it is queued, took %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting request was not woken up by a new task")
+	}
+}
+
+func TestHandleTaskWaitTimeout(t *testing.T) {
+	setupTest()
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task?wait=50ms", ""))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected handler to wait 50ms, returned after %v", elapsed)
+	}
+
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task?wait=soon", ""))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleCancelExpression(t *testing.T) {
+	setupTest()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "(1 + 2) * (3 + 4)"}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	id := created["id"]
+
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+	var response struct{ Task Task }
+	json.NewDecoder(rr.Body).Decode(&response)
+
+	rr = httptest.NewRecorder()
+	handleExpressionByID(rr, createTestRequest("DELETE", "/api/v1/expressions/"+id, ""))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if status := expressions[id].Status; status != "cancelled" {
+		t.Errorf("Expected status cancelled, got %s", status)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("Expected only the leased task to remain, got %d tasks", len(tasks))
+	}
+
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected no tasks of a cancelled expression, got status %v", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]interface{}{"id": response.Task.ID, "result": 3})
+	handleTask(rr, createTestRequest("POST", "/internal/task", string(body)))
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected late result to be rejected with %v, got %v", http.StatusConflict, rr.Code)
+	}
+	if len(tasks) != 0 || len(sched.byExpression) != 0 || len(sched.leased) != 0 {
+		t.Errorf("Expected cancelled expression to be cleaned up, got %d tasks", len(tasks))
+	}
+	if status := expressions[id].Status; status != "cancelled" {
+		t.Errorf("Expected status to stay cancelled, got %s", status)
+	}
+
+	tests := []struct {
+		name           string
+		expressionID   string
+		expectedStatus int
 	}{
 		{
-			name:     "Simple expression",
-			expr:     "2 + 3",
-			expected: []string{"2", "+", "3"},
-		},
-		{
-			name:     "Complex expression",
-			expr:     "2 + 3 * 4",
-			expected: []string{"2", "+", "3", "*", "4"},
+			name:           "Already cancelled",
+			expressionID:   id,
+			expectedStatus: http.StatusConflict,
 		},
 		{
-			name:     "Expression with parentheses",
-			expr:     "(2 + 3) * 4",
-			expected: []string{"(", "2", "+", "3", ")", "*", "4"},
+			name:           "Non-existing expression",
+			expressionID:   "nonexistent",
+			expectedStatus: http.StatusNotFound,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := tokenize(tt.expr)
-			if len(result) != len(tt.expected) {
-				t.Errorf("Expected %d tokens, got %d", len(tt.expected), len(result))
-				return
-			}
-			for i, token := range result {
-				if token != tt.expected[i] {
-					t.Errorf("Expected token %s at position %d, got %s", tt.expected[i], i, token)
-				}
+			rr := httptest.NewRecorder()
+			handleExpressionByID(rr, createTestRequest("DELETE", "/api/v1/expressions/"+tt.expressionID, ""))
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.expectedStatus)
 			}
 		})
 	}
 }
+
+func TestReapCancelledTasks(t *testing.T) {
+	setupTest()
+
+	expressions["expr1"] = &Expression{ID: "expr1", Status: "pending"}
+	sched.add([]*Task{{ID: "leased", ExpressionID: "expr1", IsProcessing: true, Attempts: 1}})
+
+	cancelExpression(expressions["expr1"])
+	reapExpiredTasks(time.Now())
+
+	if len(tasks) != 0 {
+		t.Errorf("Expected expired task of a cancelled expression to be dropped, got %d tasks", len(tasks))
+	}
+	if task := sched.next(); task != nil {
+		t.Errorf("Expected nothing to be re-queued, got %s", task.ID)
+	}
+}
+
+func TestReapCancelledTaskOnLastAttempt(t *testing.T) {
+	setupTest()
+
+	expr, err := calculate(generateID(), CalculateRequest{Expression: "2 + 3"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dispatched := dispatchTask(t.Context(), 0)
+	if dispatched == nil {
+		t.Fatal("Expected a task to be dispatched")
+	}
+	tasks[dispatched.ID].Attempts = task_max_attempts
+
+	sub := events.subscribe(expr.ID)
+	defer events.unsubscribe(sub)
+
+	cancelExpression(expr)
+	reapExpiredTasks(time.Now().Add(time.Hour))
+
+	if expr.Status != "cancelled" || expr.Error != "" {
+		t.Errorf("Expected the expression to stay cancelled, got %s %q", expr.Status, expr.Error)
+	}
+	if len(tasks) != 0 || len(sched.leased) != 0 {
+		t.Errorf("Expected the leased task to be released, got %d tasks, %d leased", len(tasks), len(sched.leased))
+	}
+
+	statuses := 0
+	for len(sub.events) > 0 {
+		if e := <-sub.events; e.Name == eventStatus {
+			statuses++
+		}
+	}
+	if statuses != 1 {
+		t.Errorf("Expected a single status event, got %d", statuses)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/Raikh/calc_micro/internal/ast"
+)
+
+// Optimization levels of an expression. By default identical
+// subexpressions share one task, "fold" also computes operations on
+// number literals before tasks are built and "none" dispatches every
+// operation to agents.
+const (
+	optimizationNone = "none"
+	optimizationCSE  = "cse"
+	optimizationFold = "fold"
+)
+
+func validOptimization(optimization string) bool {
+	switch optimization {
+	case "", optimizationNone, optimizationCSE, optimizationFold:
+		return true
+	}
+	return false
+}
+
+// operationKey identifies an operation on given operands, tasks with equal
+// keys compute the same value
+func operationKey(operation string, operands []Operand) string {
+	var key strings.Builder
+	key.WriteString(operation)
+	for _, operand := range operands {
+		key.WriteByte(' ')
+		switch {
+		case operand.TaskID != "":
+			key.WriteString("#" + operand.TaskID)
+		case operand.Exact != "":
+			key.WriteString(operand.Exact)
+		default:
+			key.WriteString(strconv.FormatFloat(operand.Value, 'g', -1, 64))
+		}
+	}
+	return key.String()
+}
+
+// foldConstants replaces subtrees made of number literals only with
+// their value. Variables are not folded even though they are bound, the
+// same tree is lowered with other bindings by formula runs. The tree is
+// copied, the parsed one may be shared. Folded numbers are in the
+// format of the precision mode, e.g. "1/3" for exact.
+func (l *lowering) foldConstants(node ast.Node) ast.Node {
+	switch n := node.(type) {
+	case *ast.Unary:
+		operand := l.foldConstants(n.Operand)
+		operator := n.Operator
+		if operator == "-" {
+			operator = "neg"
+		}
+		if folded, ok := l.foldNode(operator, n.Position, operand); ok {
+			return folded
+		}
+		return &ast.Unary{Operator: n.Operator, Operand: operand, Position: n.Position}
+	case *ast.Binary:
+		left := l.foldConstants(n.Left)
+		right := l.foldConstants(n.Right)
+		if folded, ok := l.foldNode(n.Operator, n.Position, left, right); ok {
+			return folded
+		}
+		return &ast.Binary{Operator: n.Operator, Left: left, Right: right, Position: n.Position}
+	case *ast.Call:
+		arguments := make([]ast.Node, len(n.Arguments))
+		for idx, argument := range n.Arguments {
+			arguments[idx] = l.foldConstants(argument)
+		}
+		if number, ok := arguments[0].(*ast.Number); ok && n.Function == "if" {
+			if operand := l.literal(number.Value); isTruthy(operand.Value, operand.Exact) {
+				return arguments[1]
+			}
+			return arguments[2]
+		}
+		if !functions[n.Function].Variadic {
+			if folded, ok := l.foldNode(n.Function, n.Position, arguments...); ok {
+				return folded
+			}
+		} else if folded, ok := l.foldVariadic(n.Function, n.Position, arguments); ok {
+			return folded
+		}
+		return &ast.Call{Function: n.Function, Arguments: arguments, Position: n.Position}
+	default:
+		return node
+	}
+}
+
+// foldVariadic reduces the arguments pairwise from the right like the
+// lowering does
+func (l *lowering) foldVariadic(function string, position int, arguments []ast.Node) (ast.Node, bool) {
+	result := arguments[len(arguments)-1]
+	for idx := len(arguments) - 2; idx >= 0; idx-- {
+		folded, ok := l.foldNode(function, position, arguments[idx], result)
+		if !ok {
+			return nil, false
+		}
+		result = folded
+	}
+	_, ok := result.(*ast.Number)
+	return result, ok
+}
+
+// foldNode computes an operation on number nodes
+func (l *lowering) foldNode(operation string, position int, arguments ...ast.Node) (ast.Node, bool) {
+	operands := make([]Operand, len(arguments))
+	for idx, argument := range arguments {
+		number, ok := argument.(*ast.Number)
+		if !ok {
+			return nil, false
+		}
+		operands[idx] = l.literal(number.Value)
+	}
+
+	result, ok := foldOperation(operation, operands, l.exact())
+	if !ok {
+		return nil, false
+	}
+	if l.exact() {
+		return &ast.Number{Value: result.Exact, Position: position}, true
+	}
+	return &ast.Number{Value: strconv.FormatFloat(result.Value, 'g', -1, 64), Position: position}, true
+}
+
+// foldOperation computes an operation on literal operands the way agents
+// do. It reports false when the operation would fail, such errors are
+// left to agents so they are reported as usual.
+func foldOperation(operation string, operands []Operand, exact bool) (Operand, bool) {
+	if exact {
+		return foldExact(operation, operands)
+	}
+
+	arg1 := operands[0].Value
+	var arg2 float64
+	if len(operands) > 1 {
+		arg2 = operands[1].Value
+	}
+
+	var result float64
+	switch operation {
+	case "+":
+		result = arg1 + arg2
+	case "-":
+		result = arg1 - arg2
+	case "*":
+		result = arg1 * arg2
+	case "/":
+		if arg2 == 0 {
+			return Operand{}, false
+		}
+		result = arg1 / arg2
+	case "%":
+		if arg2 == 0 {
+			return Operand{}, false
+		}
+		result = math.Mod(arg1, arg2)
+	case "^":
+		result = math.Pow(arg1, arg2)
+	case "neg":
+		result = -arg1
+	case "abs":
+		result = math.Abs(arg1)
+	case "sqrt":
+		result = math.Sqrt(arg1)
+	case "exp":
+		result = math.Exp(arg1)
+	case "ln":
+		result = math.Log(arg1)
+	case "log":
+		result = math.Log10(arg1)
+	case "sin":
+		result = math.Sin(arg1)
+	case "cos":
+		result = math.Cos(arg1)
+	case "tan":
+		result = math.Tan(arg1)
+	case "floor":
+		result = math.Floor(arg1)
+	case "ceil":
+		result = math.Ceil(arg1)
+	case "round":
+		result = math.Round(arg1)
+	case "min":
+		result = math.Min(arg1, arg2)
+	case "max":
+		result = math.Max(arg1, arg2)
+	case "==", "!=", "<", "<=", ">", ">=":
+		result = foldBool(compareFloats(operation, arg1, arg2))
+	case "&&":
+		result = foldBool(arg1 != 0 && arg2 != 0)
+	case "||":
+		result = foldBool(arg1 != 0 || arg2 != 0)
+	case "!":
+		result = foldBool(arg1 == 0)
+	default:
+		return Operand{}, false
+	}
+
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return Operand{}, false
+	}
+	return Operand{Value: result}, true
+}
+
+// foldExact covers the rational operations with a cheap exact result,
+// the rest is computed by agents
+func foldExact(operation string, operands []Operand) (Operand, bool) {
+	arg1, _ := new(big.Rat).SetString(operands[0].Exact)
+	arg2 := new(big.Rat)
+	if len(operands) > 1 {
+		arg2, _ = arg2.SetString(operands[1].Exact)
+	}
+
+	result := new(big.Rat)
+	switch operation {
+	case "+":
+		result.Add(arg1, arg2)
+	case "-":
+		result.Sub(arg1, arg2)
+	case "*":
+		result.Mul(arg1, arg2)
+	case "/":
+		if arg2.Sign() == 0 {
+			return Operand{}, false
+		}
+		result.Quo(arg1, arg2)
+	case "neg":
+		result.Neg(arg1)
+	case "abs":
+		result.Abs(arg1)
+	case "min":
+		result.Set(arg1)
+		if arg2.Cmp(arg1) < 0 {
+			result.Set(arg2)
+		}
+	case "max":
+		result.Set(arg1)
+		if arg2.Cmp(arg1) > 0 {
+			result.Set(arg2)
+		}
+	case "==", "!=", "<", "<=", ">", ">=":
+		cmp := arg1.Cmp(arg2)
+		result.SetFloat64(foldBool(compareFloats(operation, float64(cmp), 0)))
+	case "&&":
+		result.SetFloat64(foldBool(arg1.Sign() != 0 && arg2.Sign() != 0))
+	case "||":
+		result.SetFloat64(foldBool(arg1.Sign() != 0 || arg2.Sign() != 0))
+	case "!":
+		result.SetFloat64(foldBool(arg1.Sign() == 0))
+	default:
+		return Operand{}, false
+	}
+	return exactOperand(result.RatString()), true
+}
+
+func compareFloats(operation string, arg1 float64, arg2 float64) bool {
+	switch operation {
+	case "==":
+		return arg1 == arg2
+	case "!=":
+		return arg1 != arg2
+	case "<":
+		return arg1 < arg2
+	case "<=":
+		return arg1 <= arg2
+	case ">":
+		return arg1 > arg2
+	default:
+		return arg1 >= arg2
+	}
+}
+
+func foldBool(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseExpressionOptimization(t *testing.T) {
+	tests := []struct {
+		expression    string
+		optimization  string
+		expectedTasks int
+	}{
+		{"(a + b) * (a + b)", "", 2},
+		{"(a + b) * (a + b)", optimizationNone, 3},
+		{"(a + b) * (a + b)", optimizationFold, 2},
+		{"sqrt(x * x) + sqrt(x * x) + x * x", optimizationCSE, 4},
+		{"2 * 3 * x", optimizationCSE, 2},
+		{"2 * 3 * x", optimizationFold, 1},
+		{"x * (2 * 3)", optimizationFold, 1},
+		{"max(1, 2, 3) + sqrt(16) > 6 && x", optimizationFold, 1},
+		// Failing operations are left to agents
+		{"1 / 0 + 2", optimizationFold, 2},
+		{"sqrt(-1)", optimizationFold, 1},
+		// Branches share the tasks built before them, not each other's
+		{"if(x > 0, (x + 1) * 2, (x + 1) * 3)", optimizationCSE, 6},
+		{"(x + 1) + if(x > 0, (x + 1) * 2, (x + 1) * 3)", optimizationCSE, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression+" "+tt.optimization, func(t *testing.T) {
+			options := EvalOptions{
+				Variables:    map[string]float64{"a": 1, "b": 2, "x": 3},
+				Optimization: tt.optimization,
+			}
+			tasks, _, err := parseExpression(tt.expression, options, "test-expr-id")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(tasks) != tt.expectedTasks {
+				t.Errorf("Expected %d tasks, got %d", tt.expectedTasks, len(tasks))
+			}
+		})
+	}
+}
+
+func TestParseExpressionSharedTask(t *testing.T) {
+	tasks, _, err := parseExpression("(a + b) * (a + b)", EvalOptions{Variables: map[string]float64{"a": 1, "b": 2}}, "test-expr-id")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(tasks))
+	}
+	product := tasks[1]
+	if product.Operands[0].TaskID != tasks[0].ID || product.Operands[1].TaskID != tasks[0].ID {
+		t.Errorf("Expected both operands to reference %s, got %v", tasks[0].ID, product.Operands)
+	}
+}
+
+func TestEvaluateOptimized(t *testing.T) {
+	tests := []struct {
+		expression   string
+		optimization string
+		precision    string
+		expected     float64
+		exact        string
+	}{
+		{"(x + 1) * (x + 1) - (x + 1)", optimizationCSE, "", 20, ""},
+		{"2 * 3 * x", optimizationFold, "", 24, ""},
+		{"if(1 + 1 == 2, x, 0) + min(x, x)", optimizationFold, "", 8, ""},
+		{"(0.1 + 0.2) * x", optimizationFold, precisionExact, 1.2, "1.2"},
+		{"1 / 3 + x / 3", optimizationFold, precisionExact, 5.0 / 3, "5/3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			setupTest()
+
+			expr := evaluateRequest(t, map[string]interface{}{
+				"expression":   tt.expression,
+				"variables":    map[string]float64{"x": 4},
+				"optimization": tt.optimization,
+				"precision":    tt.precision,
+			})
+			if expr.Status != "completed" || expr.Result != tt.expected || expr.ExactResult != tt.exact {
+				t.Errorf("Got %s %v %q, want completed %v %q", expr.Status, expr.Result, expr.ExactResult, tt.expected, tt.exact)
+			}
+			if len(tasks) != 0 {
+				t.Errorf("Expected all tasks to be removed, got %d", len(tasks))
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Raikh/calc_micro/internal/ast"
+)
+
+type Token struct {
+	Value    string
+	Position int
+}
+
+// unaryMinus is the token of a '-' that negates its operand. No
+// identifier or operator a user types can look like it.
+const unaryMinus = "u-"
+
+// SyntaxError describes why an expression was rejected. Position is
+// a zero-based character offset of Token in the source expression.
+type SyntaxError struct {
+	Kind     string `json:"kind"`
+	Token    string `json:"token"`
+	Position int    `json:"position"`
+}
+
+const (
+	ErrInvalidCharacter     = "invalid_character"
+	ErrInvalidNumber        = "invalid_number"
+	ErrNumberOutOfRange     = "number_out_of_range"
+	ErrUnexpectedToken      = "unexpected_token"
+	ErrUnexpectedEnd        = "unexpected_end"
+	ErrUnmatchedParenthesis = "unmatched_parenthesis"
+	ErrUnclosedParenthesis  = "unclosed_parenthesis"
+	ErrFunctionNotCalled    = "function_not_called"
+	ErrUnknownFunction      = "unknown_function"
+	ErrArgumentCount        = "wrong_argument_count"
+)
+
+func (e *SyntaxError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at position %d", e.Kind, e.Position)
+	}
+	return fmt.Sprintf("%s %q at position %d", e.Kind, e.Token, e.Position)
+}
+
+// UnboundVariablesError lists variables of an expression that have no
+// value in the submitted bindings, in order of their first appearance.
+type UnboundVariablesError struct {
+	Names []string
+}
+
+func (e *UnboundVariablesError) Error() string {
+	return "unbound variables: " + strings.Join(e.Names, ", ")
+}
+
+// Unary minus binds looser than exponentiation, so -2^2 is -(2^2).
+// Comparisons and boolean operators follow C.
+var precedence = map[string]int{
+	"||":       1,
+	"&&":       2,
+	"==":       3,
+	"!=":       3,
+	"<":        4,
+	"<=":       4,
+	">":        4,
+	">=":       4,
+	"+":        5,
+	"-":        5,
+	"*":        6,
+	"/":        6,
+	"%":        6,
+	unaryMinus: 7,
+	"!":        7,
+	"^":        8,
+}
+
+var rightAssociative = map[string]bool{
+	"^": true,
+}
+
+// callFrame tracks an open parenthesis. For a function call it counts
+// the arguments parsed so far.
+type callFrame struct {
+	function *Token
+	args     int
+}
+
+// parse builds the syntax tree of expression with the shunting-yard
+// algorithm, validating the token order along the way. Variables are
+// kept by name and bound when the tree is lowered to tasks. With
+// implicitMultiplication adjacent operands are multiplied.
+func parse(expr string, implicitMultiplication bool) (ast.Node, error) {
+	var output []ast.Node
+	var stack []Token
+	var frames []callFrame
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if implicitMultiplication {
+		tokens = insertImplicitMultiplication(tokens)
+	}
+
+	// apply pops the operands of an operator off the output
+	apply := func(operator Token) {
+		if operator.Value == unaryMinus || operator.Value == "!" {
+			value := operator.Value
+			if value == unaryMinus {
+				value = "-"
+			}
+			output[len(output)-1] = &ast.Unary{Operator: value, Operand: output[len(output)-1], Position: operator.Position}
+			return
+		}
+		left, right := output[len(output)-2], output[len(output)-1]
+		output = output[:len(output)-2]
+		output = append(output, &ast.Binary{Operator: operator.Value, Left: left, Right: right, Position: operator.Position})
+	}
+
+	expectOperand := true
+	for idx, token := range tokens {
+		switch token.Value {
+		case unaryMinus, "!":
+			// Prefix operator, its operand is not parsed yet
+			if !expectOperand {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			stack = append(stack, token)
+		case "+", "-", "*", "/", "%", "^", "==", "!=", "<", "<=", ">", ">=", "&&", "||":
+			if expectOperand {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			for len(stack) > 0 && popsBefore(stack[len(stack)-1].Value, token.Value) {
+				apply(stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			stack = append(stack, token)
+			expectOperand = true
+		case "(":
+			if !expectOperand {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			frame := callFrame{}
+			if idx > 0 && isIdentifier(tokens[idx-1].Value) {
+				frame.function = &tokens[idx-1]
+			}
+			frames = append(frames, frame)
+			stack = append(stack, token)
+		case ",":
+			if expectOperand || len(frames) == 0 || frames[len(frames)-1].function == nil {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			for stack[len(stack)-1].Value != "(" {
+				apply(stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			frames[len(frames)-1].args++
+			expectOperand = true
+		case ")":
+			if len(frames) == 0 {
+				return nil, &SyntaxError{ErrUnmatchedParenthesis, token.Value, token.Position}
+			}
+			frame := frames[len(frames)-1]
+			// Only a function call may have no arguments, its arity is checked below
+			if expectOperand && (frame.function == nil || tokens[idx-1].Value != "(") {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			for stack[len(stack)-1].Value != "(" {
+				apply(stack[len(stack)-1])
+				stack = stack[:len(stack)-1]
+			}
+			stack = stack[:len(stack)-1]
+			frames = frames[:len(frames)-1]
+
+			if frame.function != nil {
+				if !expectOperand {
+					frame.args++
+				}
+				if err := checkArguments(frame.function, frame.args); err != nil {
+					return nil, err
+				}
+				call := &ast.Call{
+					Function:  frame.function.Value,
+					Arguments: append([]ast.Node{}, output[len(output)-frame.args:]...),
+					Position:  frame.function.Position,
+				}
+				output = append(output[:len(output)-frame.args], call)
+			}
+			expectOperand = false
+		default:
+			if !expectOperand {
+				return nil, &SyntaxError{ErrUnexpectedToken, token.Value, token.Position}
+			}
+			if isIdentifier(token.Value) {
+				_, isFunction := functions[token.Value]
+				if idx+1 == len(tokens) || tokens[idx+1].Value != "(" {
+					// Function names are reserved, so a variable never
+					// shadows an operation of a task
+					if isFunction {
+						return nil, &SyntaxError{ErrFunctionNotCalled, token.Value, token.Position}
+					}
+					output = append(output, &ast.Variable{Name: token.Value, Position: token.Position})
+					expectOperand = false
+					continue
+				}
+				if !isFunction {
+					return nil, &SyntaxError{ErrUnknownFunction, token.Value, token.Position}
+				}
+				// The call is built when its parenthesis is closed
+				continue
+			}
+			output = append(output, &ast.Number{Value: token.Value, Position: token.Position})
+			expectOperand = false
+		}
+	}
+
+	if expectOperand {
+		return nil, &SyntaxError{ErrUnexpectedEnd, "", len([]rune(expr))}
+	}
+
+	for len(stack) > 0 {
+		token := stack[len(stack)-1]
+		if token.Value == "(" {
+			return nil, &SyntaxError{ErrUnclosedParenthesis, token.Value, token.Position}
+		}
+		apply(token)
+		stack = stack[:len(stack)-1]
+	}
+
+	return output[0], nil
+}
+
+// insertImplicitMultiplication puts "*" between a token that ends an
+// operand and a token that starts one: 2(3+4), (1+2)(3+4), 3x, x(y+1).
+// The inserted operator has the usual precedence of "*", so 1/2x is
+// (1/2)*x and 2x^2 is 2*(x^2). Two numbers in a row stay an error.
+func insertImplicitMultiplication(tokens []Token) []Token {
+	var result []Token
+	for idx, token := range tokens {
+		if idx > 0 && endsOperand(tokens, idx-1) && startsOperand(token) &&
+			!(isNumber(tokens[idx-1].Value) && isNumber(token.Value)) {
+			result = append(result, Token{"*", token.Position})
+		}
+		result = append(result, token)
+	}
+	return result
+}
+
+func endsOperand(tokens []Token, idx int) bool {
+	value := tokens[idx].Value
+	if isIdentifier(value) {
+		_, isFunction := functions[value]
+		return !isFunction
+	}
+	return value == ")" || isNumber(value)
+}
+
+func startsOperand(token Token) bool {
+	return token.Value == "(" || isIdentifier(token.Value) || isNumber(token.Value)
+}
+
+func isNumber(value string) bool {
+	return isDigit([]rune(value)[0]) || value[0] == '.'
+}
+
+// popsBefore reports whether operator on top of the stack has to be
+// output before the incoming binary operator is pushed
+func popsBefore(top string, incoming string) bool {
+	if rightAssociative[incoming] {
+		return precedence[top] > precedence[incoming]
+	}
+	return precedence[top] >= precedence[incoming]
+}
+
+// tokenize splits expression into numbers, identifiers, operators,
+// parentheses and commas. A '-' that does not follow an operand is
+// emitted as unaryMinus, a '+' there changes nothing and is dropped.
+// Two-character operators take precedence
+// over their one-character prefixes, so "!=" is never "!" and "=".
+func tokenize(expr string) ([]Token, error) {
+	var tokens []Token
+	runes := []rune(expr)
+
+	for pos := 0; pos < len(runes); {
+		char := runes[pos]
+		switch {
+		case unicode.IsSpace(char):
+			pos++
+		case char == '-' && !followsOperand(tokens):
+			tokens = append(tokens, Token{unaryMinus, pos})
+			pos++
+		case char == '+' && !followsOperand(tokens):
+			pos++
+		case pos+1 < len(runes) && slices.Contains(twoCharOperators, string(runes[pos:pos+2])):
+			tokens = append(tokens, Token{string(runes[pos : pos+2]), pos})
+			pos += 2
+		case strings.ContainsRune("+-*/%^(),<>!", char):
+			tokens = append(tokens, Token{string(char), pos})
+			pos++
+		case isDigit(char) || char == '.':
+			start := pos
+			pos = scanNumber(runes, pos)
+			value, ok := normalizeNumber(string(runes[start:pos]))
+			if !ok {
+				return nil, &SyntaxError{ErrInvalidNumber, string(runes[start:pos]), start}
+			}
+			tokens = append(tokens, Token{value, start})
+		case isIdentifierStart(char):
+			start := pos
+			for pos < len(runes) && (isIdentifierStart(runes[pos]) || isDigit(runes[pos])) {
+				pos++
+			}
+			tokens = append(tokens, Token{string(runes[start:pos]), start})
+		default:
+			return nil, &SyntaxError{ErrInvalidCharacter, string(char), pos}
+		}
+	}
+
+	return tokens, nil
+}
+
+// scanNumber returns the end of a numeric literal starting at pos: a
+// decimal with an optional fraction and exponent (6.02E+23), or an
+// integer with a 0x or 0b prefix. Digits may be separated with '_'.
+// Stray digits and dots are kept in the literal, so 1.2.3 is rejected
+// as a whole, while a letter ends it.
+func scanNumber(runes []rune, pos int) int {
+	if pos+1 < len(runes) && runes[pos] == '0' && strings.ContainsRune("xXbB", runes[pos+1]) {
+		pos += 2
+		for pos < len(runes) && (isIdentifierStart(runes[pos]) || isDigit(runes[pos])) {
+			pos++
+		}
+		return pos
+	}
+
+	isMantissa := func(char rune) bool { return isDigit(char) || char == '_' || char == '.' }
+	for pos < len(runes) && isMantissa(runes[pos]) {
+		pos++
+	}
+	if pos < len(runes) && (runes[pos] == 'e' || runes[pos] == 'E') {
+		exponent := pos + 1
+		if exponent < len(runes) && (runes[exponent] == '+' || runes[exponent] == '-') {
+			exponent++
+		}
+		// Otherwise the letter is not part of the number
+		if exponent < len(runes) && isDigit(runes[exponent]) {
+			pos = exponent
+		}
+	}
+	for pos < len(runes) && isMantissa(runes[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// normalizeNumber validates the syntax of a literal and rewrites it in
+// the plain decimal form understood by strconv.ParseFloat and
+// big.Rat.SetString. A literal float64 cannot hold is well-formed, its
+// range is checked by checkRange once the precision is known.
+func normalizeNumber(literal string) (string, bool) {
+	if len(literal) < 2 || literal[0] != '0' || !strings.ContainsRune("xXbB", rune(literal[1])) {
+		// ParseFloat follows the Go rules for '_' as well
+		_, err := strconv.ParseFloat(literal, 64)
+		return strings.ReplaceAll(literal, "_", ""), err == nil || errors.Is(err, strconv.ErrRange)
+	}
+
+	integer, ok := new(big.Int).SetString(literal, 0)
+	if !ok {
+		return "", false
+	}
+	return integer.String(), true
+}
+
+var twoCharOperators = []string{"==", "!=", "<=", ">=", "&&", "||"}
+
+func isDigit(char rune) bool {
+	return char >= '0' && char <= '9'
+}
+
+func isIdentifierStart(char rune) bool {
+	return char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+}
+
+func isIdentifier(value string) bool {
+	for idx, char := range value {
+		if !isIdentifierStart(char) && (idx == 0 || !isDigit(char)) {
+			return false
+		}
+	}
+	return true
+}
+
+func followsOperand(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	switch tokens[len(tokens)-1].Value {
+	case "+", "-", "*", "/", "%", "^", "(", ",", unaryMinus,
+		"==", "!=", "<", "<=", ">", ">=", "&&", "||", "!":
+		return false
+	default:
+		return true
+	}
+}
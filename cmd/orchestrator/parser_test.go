@@ -0,0 +1,473 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Raikh/calc_micro/internal/ast"
+)
+
+// postfix renders a syntax tree in postfix notation, variadic calls
+// are folded pairwise like the tasks built from them
+func postfix(node ast.Node) []string {
+	switch n := node.(type) {
+	case *ast.Number:
+		return []string{n.Value}
+	case *ast.Variable:
+		return []string{n.Name}
+	case *ast.Unary:
+		operator := n.Operator
+		if operator == "-" {
+			operator = "neg"
+		}
+		return append(postfix(n.Operand), operator)
+	case *ast.Binary:
+		return append(append(postfix(n.Left), postfix(n.Right)...), n.Operator)
+	case *ast.Call:
+		result := []string{}
+		for _, argument := range n.Arguments {
+			result = append(result, postfix(argument)...)
+		}
+		calls := 1
+		if functions[n.Function].Variadic {
+			calls = len(n.Arguments) - 1
+		}
+		for range calls {
+			result = append(result, n.Function)
+		}
+		return result
+	}
+	return nil
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected []string
+	}{
+		{
+			name:     "Simple expression",
+			expr:     "2 + 3",
+			expected: []string{"2", "+", "3"},
+		},
+		{
+			name:     "Complex expression",
+			expr:     "2 + 3 * 4",
+			expected: []string{"2", "+", "3", "*", "4"},
+		},
+		{
+			name:     "Expression with parentheses",
+			expr:     "(2 + 3) * 4",
+			expected: []string{"(", "2", "+", "3", ")", "*", "4"},
+		},
+		{
+			name:     "Leading unary minus",
+			expr:     "-3 + 5",
+			expected: []string{unaryMinus, "3", "+", "5"},
+		},
+		{
+			name:     "Unary minus after operator",
+			expr:     "2 * -4",
+			expected: []string{"2", "*", unaryMinus, "4"},
+		},
+		{
+			name:     "Unary minus before parentheses",
+			expr:     "-(1+2) - -1",
+			expected: []string{unaryMinus, "(", "1", "+", "2", ")", "-", unaryMinus, "1"},
+		},
+		{
+			name:     "Unary plus",
+			expr:     "+2 - +1",
+			expected: []string{"2", "-", "1"},
+		},
+		{
+			name:     "Identifier named neg",
+			expr:     "neg--neg",
+			expected: []string{"neg", "-", unaryMinus, "neg"},
+		},
+		{
+			name:     "Exponentiation and modulo",
+			expr:     "2^-3 % 4",
+			expected: []string{"2", "^", unaryMinus, "3", "%", "4"},
+		},
+		{
+			name:     "Function call",
+			expr:     "max(x1,-2)",
+			expected: []string{"max", "(", "x1", ",", unaryMinus, "2", ")"},
+		},
+		{
+			name:     "Whitespace separates numbers",
+			expr:     "2 3",
+			expected: []string{"2", "3"},
+		},
+		{
+			name:     "Comparison and boolean operators",
+			expr:     "a<=b&&!c!=d||e>-1",
+			expected: []string{"a", "<=", "b", "&&", "!", "c", "!=", "d", "||", "e", ">", unaryMinus, "1"},
+		},
+		{
+			name:     "Scientific notation",
+			expr:     "1e-5+6.02E+23-2e3",
+			expected: []string{"1e-5", "+", "6.02E+23", "-", "2e3"},
+		},
+		{
+			name:     "Exponent needs digits",
+			expr:     "2e+1",
+			expected: []string{"2e+1"},
+		},
+		{
+			name:     "Letter after a number",
+			expr:     "2e + 1",
+			expected: []string{"2", "e", "+", "1"},
+		},
+		{
+			name:     "Hexadecimal and binary",
+			expr:     "0xFF * 0b1010 - 0XaB_cD",
+			expected: []string{"255", "*", "10", "-", "43981"},
+		},
+		{
+			name:     "Digit separators",
+			expr:     "1_000_000 + .5 + 3.",
+			expected: []string{"1000000", "+", ".5", "+", "3."},
+		},
+		{
+			name:     "Literal beyond float64",
+			expr:     "1e400 - 1_0e-400",
+			expected: []string{"1e400", "-", "10e-400"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tokenize(tt.expr)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Errorf("Expected %d tokens, got %d", len(tt.expected), len(result))
+				return
+			}
+			for i, token := range result {
+				if token.Value != tt.expected[i] {
+					t.Errorf("Expected token %s at position %d, got %s", tt.expected[i], i, token.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected []string
+	}{
+		{
+			name:     "Negated literal",
+			expr:     "-3 + 5",
+			expected: []string{"3", "neg", "5", "+"},
+		},
+		{
+			name:     "Negated operand of multiplication",
+			expr:     "2 * -4",
+			expected: []string{"2", "4", "neg", "*"},
+		},
+		{
+			name:     "Unary plus",
+			expr:     "+2 * +(3 - -+1)",
+			expected: []string{"2", "3", "1", "neg", "-", "*"},
+		},
+		{
+			name:     "Negated parentheses",
+			expr:     "-(1 + 2) * 3",
+			expected: []string{"1", "2", "+", "neg", "3", "*"},
+		},
+		{
+			name:     "Right associative exponentiation",
+			expr:     "2 ^ 3 ^ 2",
+			expected: []string{"2", "3", "2", "^", "^"},
+		},
+		{
+			name:     "Exponentiation binds tighter than unary minus",
+			expr:     "-2 ^ 2",
+			expected: []string{"2", "2", "^", "neg"},
+		},
+		{
+			name:     "Negative exponent",
+			expr:     "2 ^ -3 ^ 2",
+			expected: []string{"2", "3", "2", "^", "neg", "^"},
+		},
+		{
+			name:     "Exponentiation binds tighter than multiplication",
+			expr:     "2 * 3 ^ 2",
+			expected: []string{"2", "3", "2", "^", "*"},
+		},
+		{
+			name:     "Function calls",
+			expr:     "sqrt(2) * max(3, 4, 5)",
+			expected: []string{"2", "sqrt", "3", "4", "5", "max", "max", "*"},
+		},
+		{
+			name:     "Negative argument",
+			expr:     "max(1, -2)",
+			expected: []string{"1", "2", "neg", "max"},
+		},
+		{
+			name:     "Nested calls",
+			expr:     "min(max(1, 2 + 3), 4)",
+			expected: []string{"1", "2", "3", "+", "max", "4", "min"},
+		},
+		{
+			name:     "Single argument of a variadic function",
+			expr:     "max(7) + 1",
+			expected: []string{"7", "1", "+"},
+		},
+		{
+			name:     "Negated call",
+			expr:     "-sqrt(4) ^ 2",
+			expected: []string{"4", "sqrt", "2", "^", "neg"},
+		},
+		{
+			name:     "Comparisons bind looser than arithmetic",
+			expr:     "1 + 2 < 4 && !0 || x == -y",
+			expected: []string{"1", "2", "+", "4", "<", "0", "!", "&&", "x", "y", "neg", "==", "||"},
+		},
+		{
+			name:     "Conditional",
+			expr:     "if(x > 10, x * 0.9, x)",
+			expected: []string{"x", "10", ">", "x", "0.9", "*", "x", "if"},
+		},
+		{
+			name:     "Variables",
+			expr:     "a * x + max(b, -x)",
+			expected: []string{"a", "x", "*", "b", "x", "neg", "max", "+"},
+		},
+		{
+			name:     "Variable named neg",
+			expr:     "neg + 1",
+			expected: []string{"neg", "1", "+"},
+		},
+		{
+			name:     "Modulo is left associative with multiplication",
+			expr:     "2 * 7 % 4 / 2",
+			expected: []string{"2", "7", "*", "4", "%", "2", "/"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree, err := parse(tt.expr, false)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := postfix(tree); strings.Join(result, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("parse(%q) = %v, want %v", tt.expr, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected SyntaxError
+	}{
+		{
+			name:     "Doubled operator",
+			expr:     "2 * * 3",
+			expected: SyntaxError{ErrUnexpectedToken, "*", 4},
+		},
+		{
+			name:     "Unclosed parenthesis",
+			expr:     "(2+3",
+			expected: SyntaxError{ErrUnclosedParenthesis, "(", 0},
+		},
+		{
+			name:     "Unmatched parenthesis",
+			expr:     "2+3)",
+			expected: SyntaxError{ErrUnmatchedParenthesis, ")", 3},
+		},
+		{
+			name:     "Empty parentheses",
+			expr:     "2 * ()",
+			expected: SyntaxError{ErrUnexpectedToken, ")", 5},
+		},
+		{
+			name:     "Function without parentheses",
+			expr:     "sqrt + 4",
+			expected: SyntaxError{ErrFunctionNotCalled, "sqrt", 0},
+		},
+		{
+			name:     "Adjacent variables",
+			expr:     "a b",
+			expected: SyntaxError{ErrUnexpectedToken, "b", 2},
+		},
+		{
+			name:     "Unknown function",
+			expr:     "1 + foo(2)",
+			expected: SyntaxError{ErrUnknownFunction, "foo", 4},
+		},
+		{
+			name:     "Negation is not a function",
+			expr:     "neg(3) + 1",
+			expected: SyntaxError{ErrUnknownFunction, "neg", 0},
+		},
+		{
+			name:     "Negation is not an operator",
+			expr:     "neg 3",
+			expected: SyntaxError{ErrUnexpectedToken, "3", 4},
+		},
+		{
+			name:     "Too many arguments",
+			expr:     "sqrt(1, 2)",
+			expected: SyntaxError{ErrArgumentCount, "sqrt", 0},
+		},
+		{
+			name:     "No arguments",
+			expr:     "2 * max()",
+			expected: SyntaxError{ErrArgumentCount, "max", 4},
+		},
+		{
+			name:     "Missing argument",
+			expr:     "max(1,)",
+			expected: SyntaxError{ErrUnexpectedToken, ")", 6},
+		},
+		{
+			name:     "Comma outside of a call",
+			expr:     "(1, 2)",
+			expected: SyntaxError{ErrUnexpectedToken, ",", 2},
+		},
+		{
+			name:     "Comma at top level",
+			expr:     "1, 2",
+			expected: SyntaxError{ErrUnexpectedToken, ",", 1},
+		},
+		{
+			name:     "Adjacent numbers",
+			expr:     "2 3",
+			expected: SyntaxError{ErrUnexpectedToken, "3", 2},
+		},
+		{
+			name:     "Single equals sign",
+			expr:     "x = 1",
+			expected: SyntaxError{ErrInvalidCharacter, "=", 2},
+		},
+		{
+			name:     "Negation after an operand",
+			expr:     "2 ! 3",
+			expected: SyntaxError{ErrUnexpectedToken, "!", 2},
+		},
+		{
+			name:     "Conditional without else",
+			expr:     "if(x, 1)",
+			expected: SyntaxError{ErrArgumentCount, "if", 0},
+		},
+		{
+			name:     "Malformed number",
+			expr:     "1 + 1.2.3",
+			expected: SyntaxError{ErrInvalidNumber, "1.2.3", 4},
+		},
+		{
+			name:     "Exponent followed by a fraction",
+			expr:     "1e5.5",
+			expected: SyntaxError{ErrInvalidNumber, "1e5.5", 0},
+		},
+		{
+			name:     "Doubled separator",
+			expr:     "1__000",
+			expected: SyntaxError{ErrInvalidNumber, "1__000", 0},
+		},
+		{
+			name:     "Trailing separator",
+			expr:     "2 * 1_",
+			expected: SyntaxError{ErrInvalidNumber, "1_", 4},
+		},
+		{
+			name:     "Separator next to the point",
+			expr:     "1_.5",
+			expected: SyntaxError{ErrInvalidNumber, "1_.5", 0},
+		},
+		{
+			name:     "Binary with a wrong digit",
+			expr:     "0b102",
+			expected: SyntaxError{ErrInvalidNumber, "0b102", 0},
+		},
+		{
+			name:     "Prefix without digits",
+			expr:     "0x + 1",
+			expected: SyntaxError{ErrInvalidNumber, "0x", 0},
+		},
+		{
+			name:     "Lone point",
+			expr:     "1 + .",
+			expected: SyntaxError{ErrInvalidNumber, ".", 4},
+		},
+		{
+			name:     "Trailing operator",
+			expr:     "2 * -",
+			expected: SyntaxError{ErrUnexpectedEnd, "", 5},
+		},
+		{
+			name:     "Leading binary operator",
+			expr:     "* 2",
+			expected: SyntaxError{ErrUnexpectedToken, "*", 0},
+		},
+		{
+			name:     "Position counts characters",
+			expr:     "2 ×",
+			expected: SyntaxError{ErrInvalidCharacter, "×", 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.expr, false)
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("Expected *SyntaxError, got %v", err)
+			}
+			if *syntaxErr != tt.expected {
+				t.Errorf("parse(%q) error = %+v, want %+v", tt.expr, *syntaxErr, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseImplicitMultiplication(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected []string
+	}{
+		{"2(3+4)", []string{"2", "3", "4", "+", "*"}},
+		{"(1+2)(3+4)", []string{"1", "2", "+", "3", "4", "+", "*"}},
+		{"3pi", []string{"3", "pi", "*"}},
+		{"1/2x", []string{"1", "2", "/", "x", "*"}},
+		{"2x^2", []string{"2", "x", "2", "^", "*"}},
+		{"-2x", []string{"2", "neg", "x", "*"}},
+		{"x(y+1)", []string{"x", "y", "1", "+", "*"}},
+		{"2sqrt(4)", []string{"2", "4", "sqrt", "*"}},
+		{"2e3x", []string{"2e3", "x", "*"}},
+		{"2 - 3", []string{"2", "3", "-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			tree, err := parse(tt.expr, true)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := postfix(tree); strings.Join(result, " ") != strings.Join(tt.expected, " ") {
+				t.Errorf("parse(%q) = %v, want %v", tt.expr, result, tt.expected)
+			}
+		})
+	}
+
+	// Without the flag and between two numbers juxtaposition stays an error
+	for expr, implicit := range map[string]bool{"2(3+4)": false, "2 3": true} {
+		if _, err := parse(expr, implicit); err == nil {
+			t.Errorf("parse(%q, %v) expected an error", expr, implicit)
+		}
+	}
+}
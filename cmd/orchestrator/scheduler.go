@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+)
+
+// scheduler indexes the tasks map. Every task counts its unfinished
+// dependencies and lands in the ready queue once the last of them is
+// completed, so neither dispatch nor completion scans the whole map.
+// All methods expect mutex to be held.
+type scheduler struct {
+	// ready holds IDs of tasks that can be dispatched, stale entries
+	// of removed or already dispatched tasks are skipped on pop
+	ready *list.List
+	// waiting is the number of unfinished dependencies of a task
+	waiting map[string]int
+	// dependents are the tasks consuming the result of a task
+	dependents map[string][]string
+	// byExpression lists the task IDs of every expression
+	byExpression map[string][]string
+	// unfinished is the number of not completed tasks of an expression
+	unfinished map[string]int
+	// leased are tasks dispatched to agents and waiting for a result
+	leased map[string]*Task
+	// readySignal is closed when a task is queued, nil if nobody waits
+	readySignal chan struct{}
+	// cancelled are expressions whose leased tasks are still out with agents
+	cancelled map[string]bool
+	// conditions are "if" tasks whose awaited operand is completed, the
+	// orchestrator resolves them instead of agents
+	conditions []string
+}
+
+var sched = newScheduler()
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		ready:        list.New(),
+		waiting:      make(map[string]int),
+		dependents:   make(map[string][]string),
+		byExpression: make(map[string][]string),
+		unfinished:   make(map[string]int),
+		leased:       make(map[string]*Task),
+		cancelled:    make(map[string]bool),
+	}
+}
+
+// add puts newTasks into the tasks map and queues the ones whose
+// dependencies are already completed. Tasks may come in any order.
+// Dormant tasks of conditional branches wait for selectBranch.
+func (s *scheduler) add(newTasks []*Task) {
+	for _, task := range newTasks {
+		tasks[task.ID] = task
+		s.byExpression[task.ExpressionID] = append(s.byExpression[task.ExpressionID], task.ID)
+	}
+
+	for _, task := range newTasks {
+		if task.Completed || task.Condition != "" {
+			continue
+		}
+		s.unfinished[task.ExpressionID]++
+		s.schedule(task)
+	}
+}
+
+// schedule counts the unfinished dependencies of a task and queues it
+// when there are none
+func (s *scheduler) schedule(task *Task) {
+	for _, operand := range task.dependencies() {
+		if operand.TaskID == "" {
+			continue
+		}
+		if dep, exists := tasks[operand.TaskID]; exists && dep.Completed {
+			continue
+		}
+		s.waiting[task.ID]++
+		s.dependents[operand.TaskID] = append(s.dependents[operand.TaskID], task.ID)
+	}
+
+	if task.IsProcessing {
+		s.leased[task.ID] = task
+	} else if s.waiting[task.ID] == 0 {
+		s.push(task.ID)
+	}
+}
+
+// selectBranch wakes the dormant tasks of the selected branch of an
+// "if" task and drops the tasks of the other one, including the
+// branches of conditionals nested in it.
+func (s *scheduler) selectBranch(condition *Task, onTrue bool) (activated []*Task, dropped []*Task) {
+	gated := map[string][]*Task{}
+	for _, id := range s.byExpression[condition.ExpressionID] {
+		if task, exists := tasks[id]; exists && task.Condition != "" {
+			gated[task.Condition] = append(gated[task.Condition], task)
+		}
+	}
+
+	var drop func(id string, branch func(*Task) bool)
+	drop = func(id string, branch func(*Task) bool) {
+		for _, task := range gated[id] {
+			if !branch(task) {
+				continue
+			}
+			delete(tasks, task.ID)
+			dropped = append(dropped, task)
+			drop(task.ID, func(*Task) bool { return true })
+		}
+	}
+	drop(condition.ID, func(task *Task) bool { return task.OnTrue != onTrue })
+
+	for _, task := range gated[condition.ID] {
+		if task.OnTrue == onTrue {
+			task.Condition = ""
+			activated = append(activated, task)
+		}
+	}
+	for _, task := range activated {
+		s.unfinished[task.ExpressionID]++
+		s.schedule(task)
+	}
+	return activated, dropped
+}
+
+func (s *scheduler) push(id string) {
+	if task, exists := tasks[id]; exists && task.Operation == "if" {
+		s.conditions = append(s.conditions, id)
+		return
+	}
+	s.ready.PushBack(id)
+	if s.readySignal != nil {
+		close(s.readySignal)
+		s.readySignal = nil
+	}
+}
+
+// readyChan returns a channel closed on the next push to the ready queue
+func (s *scheduler) readyChan() <-chan struct{} {
+	if s.readySignal == nil {
+		s.readySignal = make(chan struct{})
+	}
+	return s.readySignal
+}
+
+// next pops a ready task, nil if there is none
+func (s *scheduler) next() *Task {
+	for s.ready.Len() > 0 {
+		id := s.ready.Remove(s.ready.Front()).(string)
+		task, exists := tasks[id]
+		if !exists || task.Completed || task.IsProcessing {
+			continue
+		}
+		return task
+	}
+	return nil
+}
+
+func (s *scheduler) lease(task *Task) {
+	s.leased[task.ID] = task
+}
+
+// requeue returns a dispatched task to the ready queue
+func (s *scheduler) requeue(task *Task) {
+	if s.cancelled[task.ExpressionID] {
+		s.release(task)
+		return
+	}
+	task.IsProcessing = false
+	delete(s.leased, task.ID)
+	s.push(task.ID)
+}
+
+// complete marks task as completed and queues the dependents that have
+// no unfinished dependencies left. It reports whether that was the last
+// unfinished task of the expression.
+func (s *scheduler) complete(task *Task) bool {
+	if task.Completed {
+		return s.unfinished[task.ExpressionID] == 0
+	}
+	task.Completed = true
+	task.IsProcessing = false
+	delete(s.leased, task.ID)
+	delete(s.waiting, task.ID)
+
+	for _, id := range s.dependents[task.ID] {
+		s.waiting[id]--
+		if s.waiting[id] == 0 {
+			delete(s.waiting, id)
+			s.push(id)
+		}
+	}
+	delete(s.dependents, task.ID)
+
+	s.unfinished[task.ExpressionID]--
+	return s.unfinished[task.ExpressionID] == 0
+}
+
+// cancel drops the tasks of the expression that are not dispatched yet.
+// Leased tasks stay until release is called for each of them, so late
+// results can still be matched to the cancelled expression.
+func (s *scheduler) cancel(expressionID string) {
+	for _, id := range s.byExpression[expressionID] {
+		if _, leased := s.leased[id]; leased {
+			continue
+		}
+		delete(tasks, id)
+		delete(s.waiting, id)
+		delete(s.dependents, id)
+	}
+	s.cancelled[expressionID] = true
+	s.cleanupCancelled(expressionID)
+}
+
+// release drops a leased task of a cancelled expression
+func (s *scheduler) release(task *Task) {
+	delete(tasks, task.ID)
+	delete(s.leased, task.ID)
+	s.cleanupCancelled(task.ExpressionID)
+}
+
+func (s *scheduler) cleanupCancelled(expressionID string) {
+	for _, id := range s.byExpression[expressionID] {
+		if _, exists := tasks[id]; exists {
+			return
+		}
+	}
+	s.remove(expressionID)
+}
+
+// remove drops all tasks of the expression
+func (s *scheduler) remove(expressionID string) {
+	for _, id := range s.byExpression[expressionID] {
+		delete(tasks, id)
+		delete(s.waiting, id)
+		delete(s.dependents, id)
+		delete(s.leased, id)
+	}
+	delete(s.byExpression, expressionID)
+	delete(s.unfinished, expressionID)
+	delete(s.cancelled, expressionID)
+}
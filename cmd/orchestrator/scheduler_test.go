@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchedulerDependencies(t *testing.T) {
+	setupTest()
+
+	// (1 + 2) * (3 + 4) - 5, added in reverse to check the order does not matter
+	sched.add([]*Task{
+		{ID: "sub", ExpressionID: "expr1", Operands: []Operand{{TaskID: "mul"}, {Value: 5}}},
+		{ID: "mul", ExpressionID: "expr1", Operands: []Operand{{TaskID: "add1"}, {TaskID: "add2"}}},
+		{ID: "add2", ExpressionID: "expr1", Operands: []Operand{{Value: 3}, {Value: 4}}},
+		{ID: "add1", ExpressionID: "expr1", Operands: []Operand{{Value: 1}, {Value: 2}}},
+	})
+
+	first, second := sched.next(), sched.next()
+	if first == nil || second == nil || first.ID == second.ID {
+		t.Fatalf("Expected both additions to be ready, got %v and %v", first, second)
+	}
+	if task := sched.next(); task != nil {
+		t.Fatalf("Expected no more ready tasks, got %s", task.ID)
+	}
+
+	if sched.complete(first) {
+		t.Error("Expression reported finished after the first task")
+	}
+	if task := sched.next(); task != nil {
+		t.Fatalf("Expected mul to wait for the second addition, got %s", task.ID)
+	}
+	sched.complete(second)
+
+	mul := sched.next()
+	if mul == nil || mul.ID != "mul" {
+		t.Fatalf("Expected mul to be ready, got %v", mul)
+	}
+	sched.complete(mul)
+
+	sub := sched.next()
+	if sub == nil || sub.ID != "sub" {
+		t.Fatalf("Expected sub to be ready, got %v", sub)
+	}
+	if !sched.complete(sub) {
+		t.Error("Expected expression to be finished after the last task")
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	setupTest()
+
+	sched.add([]*Task{
+		{ID: "a", ExpressionID: "expr1", Operands: []Operand{{Value: 1}, {Value: 2}}},
+		{ID: "b", ExpressionID: "expr1", Operands: []Operand{{TaskID: "a"}, {Value: 2}}},
+		{ID: "c", ExpressionID: "expr2", Operands: []Operand{{Value: 1}, {Value: 2}}},
+	})
+
+	sched.remove("expr1")
+
+	if len(tasks) != 1 {
+		t.Errorf("Expected only the task of expr2 to remain, got %d tasks", len(tasks))
+	}
+	if task := sched.next(); task == nil || task.ID != "c" {
+		t.Errorf("Expected removed tasks to be skipped, got %v", task)
+	}
+	if task := sched.next(); task != nil {
+		t.Errorf("Expected no more ready tasks, got %s", task.ID)
+	}
+}
+
+// BenchmarkHandleTask measures one submit, dispatch and completion
+// round trip while the given number of expressions is blocked waiting
+// for results of leased tasks.
+func BenchmarkHandleTask(b *testing.B) {
+	for _, queued := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("queued=%d", queued), func(b *testing.B) {
+			setupTest()
+			for i := 0; i < queued; i++ {
+				handleCalculate(httptest.NewRecorder(), createTestRequest("POST", "/api/v1/calculate", `{"expression": "(1 + 2) * 3"}`))
+				handleTask(httptest.NewRecorder(), createTestRequest("GET", "/internal/task", ""))
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				handleCalculate(httptest.NewRecorder(), createTestRequest("POST", "/api/v1/calculate", `{"expression": "1 + 2"}`))
+
+				rr := httptest.NewRecorder()
+				handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+				var response struct{ Task Task }
+				if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+					b.Fatalf("Failed to decode response body: %v", err)
+				}
+
+				body := fmt.Sprintf(`{"id": %q, "result": 3}`, response.Task.ID)
+				handleTask(httptest.NewRecorder(), createTestRequest("POST", "/internal/task", body))
+			}
+		})
+	}
+}
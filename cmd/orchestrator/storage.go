@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// Storage persists expressions, their unfinished tasks and formulas.
+// The in-memory maps stay the working set, every change to them is
+// written through to the storage.
+type Storage interface {
+	SaveExpression(expr *Expression) error
+	SaveTasks(tasks []*Task) error
+	// SaveBatch stores new expressions with their tasks at once
+	SaveBatch(exprs []*Expression, tasks []*Task) error
+	DeleteTasks(expressionID string) error
+	DeleteTask(task *Task) error
+	SaveFormula(formula *Formula) error
+	LoadExpressions() ([]*Expression, error)
+	LoadTasks() ([]*Task, error)
+	LoadFormulas() ([]*Formula, error)
+	Close() error
+}
+
+var store Storage = memoryStorage{}
+
+// memoryStorage keeps nothing beyond the in-memory maps
+type memoryStorage struct{}
+
+func (memoryStorage) SaveExpression(expr *Expression) error { return nil }
+func (memoryStorage) SaveTasks(tasks []*Task) error         { return nil }
+func (memoryStorage) SaveBatch(exprs []*Expression, tasks []*Task) error {
+	return nil
+}
+func (memoryStorage) DeleteTasks(expressionID string) error   { return nil }
+func (memoryStorage) DeleteTask(task *Task) error             { return nil }
+func (memoryStorage) SaveFormula(formula *Formula) error      { return nil }
+func (memoryStorage) LoadExpressions() ([]*Expression, error) { return nil, nil }
+func (memoryStorage) LoadTasks() ([]*Task, error)             { return nil, nil }
+func (memoryStorage) LoadFormulas() ([]*Formula, error)       { return nil, nil }
+func (memoryStorage) Close() error                            { return nil }
+
+func openStorage(storageType string, path string) (Storage, error) {
+	switch storageType {
+	case "memory":
+		return memoryStorage{}, nil
+	case "bolt":
+		return openBoltStorage(path)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", storageType)
+	}
+}
+
+// loadState fills the in-memory maps from storage. Tasks of pending
+// expressions are re-queued, including the ones that were dispatched
+// to agents before the restart.
+func loadState() error {
+	storedExpressions, err := store.LoadExpressions()
+	if err != nil {
+		return err
+	}
+	storedTasks, err := store.LoadTasks()
+	if err != nil {
+		return err
+	}
+	storedFormulas, err := store.LoadFormulas()
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, formula := range storedFormulas {
+		if formula.tree, err = parse(formula.Expr, formula.ImplicitMultiplication); err != nil {
+			log.Println("Error parsing formula", formula.ID+":", err)
+			continue
+		}
+		formulas[formula.ID] = formula
+	}
+	for _, expr := range storedExpressions {
+		expressions[expr.ID] = expr
+		// Callbacks interrupted by a restart are resumed
+		if expr.Status != "pending" {
+			resumeCallback(expr)
+		}
+	}
+
+	var pendingTasks []*Task
+	for _, task := range storedTasks {
+		expr, exists := expressions[task.ExpressionID]
+		if !exists || expr.Status != "pending" {
+			continue
+		}
+		task.IsProcessing = false
+		task.Attempts = 0
+		pendingTasks = append(pendingTasks, task)
+	}
+	sched.add(pendingTasks)
+	resolveConditions()
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	expressionsBucket = []byte("expressions")
+	tasksBucket       = []byte("tasks")
+	formulasBucket    = []byte("formulas")
+)
+
+// boltStorage keeps gob encoded records in a bolt file. Task keys are
+// prefixed with the expression ID, so tasks of one expression can be
+// removed with a single prefix scan.
+type boltStorage struct {
+	db *bolt.DB
+}
+
+func openBoltStorage(path string) (*boltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{expressionsBucket, tasksBucket, formulasBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStorage{db: db}, nil
+}
+
+func taskKey(task *Task) []byte {
+	return []byte(task.ExpressionID + "/" + task.ID)
+}
+
+func encodeRecord(record interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *boltStorage) SaveExpression(expr *Expression) error {
+	data, err := encodeRecord(expr)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(expressionsBucket).Put([]byte(expr.ID), data)
+	})
+}
+
+func (s *boltStorage) SaveTasks(tasks []*Task) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tasksBucket)
+		for _, task := range tasks {
+			data, err := encodeRecord(task)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(taskKey(task), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveBatch writes tasks and expressions in a single transaction, so a
+// batch costs one sync of the file
+func (s *boltStorage) SaveBatch(exprs []*Expression, tasks []*Task) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, task := range tasks {
+			data, err := encodeRecord(task)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(tasksBucket).Put(taskKey(task), data); err != nil {
+				return err
+			}
+		}
+		for _, expr := range exprs {
+			data, err := encodeRecord(expr)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(expressionsBucket).Put([]byte(expr.ID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) DeleteTasks(expressionID string) error {
+	prefix := []byte(expressionID + "/")
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(tasksBucket).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Seek(prefix) {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStorage) DeleteTask(task *Task) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).Delete(taskKey(task))
+	})
+}
+
+func (s *boltStorage) SaveFormula(formula *Formula) error {
+	data, err := encodeRecord(formula)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(formulasBucket).Put([]byte(formula.ID), data)
+	})
+}
+
+func (s *boltStorage) LoadExpressions() ([]*Expression, error) {
+	var result []*Expression
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(expressionsBucket).ForEach(func(key, data []byte) error {
+			expr := &Expression{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(expr); err != nil {
+				return err
+			}
+			result = append(result, expr)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStorage) LoadTasks() ([]*Task, error) {
+	var result []*Task
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(key, data []byte) error {
+			task := &Task{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(task); err != nil {
+				return err
+			}
+			result = append(result, task)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStorage) LoadFormulas() ([]*Formula, error) {
+	var result []*Formula
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(formulasBucket).ForEach(func(key, data []byte) error {
+			formula := &Formula{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(formula); err != nil {
+				return err
+			}
+			result = append(result, formula)
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStorage) Close() error {
+	return s.db.Close()
+}
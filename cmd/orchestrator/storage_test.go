@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupBoltStorage(t *testing.T) *boltStorage {
+	t.Helper()
+
+	storage, err := openBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestBoltStorageRoundTrip(t *testing.T) {
+	storage := setupBoltStorage(t)
+
+	expr := &Expression{ID: "expr1", Expr: "-(1 + x)", Status: "pending", Variables: map[string]float64{"x": 2}}
+	if err := storage.SaveExpression(expr); err != nil {
+		t.Fatalf("SaveExpression() error = %v", err)
+	}
+	err := storage.SaveTasks([]*Task{
+		{ID: "add", ExpressionID: "expr1", Operation: "+", Operands: []Operand{{Value: 1}, {Value: 2}}, Completed: true, Result: 3},
+		{ID: "neg", ExpressionID: "expr1", Operation: "neg", Operands: []Operand{{TaskID: "add"}}},
+		{ID: "other", ExpressionID: "expr2", Operation: "*"},
+	})
+	if err != nil {
+		t.Fatalf("SaveTasks() error = %v", err)
+	}
+
+	loadedExpressions, err := storage.LoadExpressions()
+	if err != nil {
+		t.Fatalf("LoadExpressions() error = %v", err)
+	}
+	if len(loadedExpressions) != 1 || !reflect.DeepEqual(loadedExpressions[0], expr) {
+		t.Errorf("LoadExpressions() = %v, want [%v]", loadedExpressions, expr)
+	}
+
+	loadedTasks, err := storage.LoadTasks()
+	if err != nil {
+		t.Fatalf("LoadTasks() error = %v", err)
+	}
+	if len(loadedTasks) != 3 {
+		t.Fatalf("Expected 3 tasks, got %d", len(loadedTasks))
+	}
+	for _, task := range loadedTasks {
+		if task.ID == "add" && (!task.Completed || task.Result != 3 || len(task.Operands) != 2) {
+			t.Errorf("Task add was not restored, got %+v", task)
+		}
+		if task.ID == "neg" && (len(task.Operands) != 1 || task.Operands[0].TaskID != "add") {
+			t.Errorf("Task neg operands were not restored, got %+v", task.Operands)
+		}
+	}
+
+	if err := storage.DeleteTasks("expr1"); err != nil {
+		t.Fatalf("DeleteTasks() error = %v", err)
+	}
+	loadedTasks, _ = storage.LoadTasks()
+	if len(loadedTasks) != 1 || loadedTasks[0].ID != "other" {
+		t.Errorf("Expected only task of expr2 to remain, got %v", loadedTasks)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	setupTest()
+	store = setupBoltStorage(t)
+	defer func() { store = memoryStorage{} }()
+
+	store.SaveExpression(&Expression{ID: "pending", Status: "pending"})
+	store.SaveExpression(&Expression{ID: "done", Status: "completed", Result: 5})
+	store.SaveTasks([]*Task{
+		{ID: "leased", ExpressionID: "pending", IsProcessing: true, Attempts: 2},
+		{ID: "stale", ExpressionID: "done"},
+	})
+	store.SaveFormula(&Formula{ID: "formula", Expr: "a + 1"})
+
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+
+	if len(expressions) != 2 {
+		t.Errorf("Expected 2 expressions, got %d", len(expressions))
+	}
+	if formula, exists := formulas["formula"]; !exists || formula.tree == nil {
+		t.Errorf("Expected formula to be loaded, got %+v", formula)
+	}
+	if _, exists := tasks["stale"]; exists {
+		t.Error("Expected task of a finished expression to be skipped")
+	}
+	task, exists := tasks["leased"]
+	if !exists {
+		t.Fatal("Expected task of a pending expression to be loaded")
+	}
+	if task.IsProcessing || task.Attempts != 0 {
+		t.Errorf("Expected dispatched task to be re-queued, got %+v", task)
+	}
+}
+
+func TestEvaluateAcrossRestart(t *testing.T) {
+	setupTest()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storage, err := openBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	store = storage
+	defer func() { store = memoryStorage{} }()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "(1 + 2) * (3 + 4)"}`))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	// Take one task and complete it, leave another one leased
+	var response struct{ Task Task }
+	rr = httptest.NewRecorder()
+	handleTask(rr, createTestRequest("GET", "/internal/task", ""))
+	json.NewDecoder(rr.Body).Decode(&response)
+	body, _ := json.Marshal(map[string]interface{}{"id": response.Task.ID, "result": response.Task.Arg1 + response.Task.Arg2})
+	handleTask(httptest.NewRecorder(), createTestRequest("POST", "/internal/task", string(body)))
+	handleTask(httptest.NewRecorder(), createTestRequest("GET", "/internal/task", ""))
+
+	storage.Close()
+	setupTest()
+	if store, err = openBoltStorage(path); err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	if len(tasks) != 3 {
+		t.Fatalf("Expected 3 tasks after restart, got %d", len(tasks))
+	}
+
+	expr := finishExpression(t, created["id"])
+	if expr.Status != "completed" || expr.Result != 21 {
+		t.Errorf("Expected completed with result 21, got %s with %v", expr.Status, expr.Result)
+	}
+}
+
+func TestConditionalAcrossRestart(t *testing.T) {
+	setupTest()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	storage, err := openBoltStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	store = storage
+	defer func() { store = memoryStorage{} }()
+
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "if(x > 1, x * 2, x + 1)", "variables": {"x": 5}}`))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	// Complete the condition, the else branch is dropped
+	task := dispatchTask(t.Context(), 0)
+	if err := submitResult(task.ID, 1, "", ""); err != nil {
+		t.Fatalf("submitResult() error = %v", err)
+	}
+
+	storage.Close()
+	setupTest()
+	if store, err = openBoltStorage(path); err != nil {
+		t.Fatalf("Failed to reopen storage: %v", err)
+	}
+	defer store.Close()
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	for _, task := range tasks {
+		if task.Operation == "+" {
+			t.Error("Expected the task of the dropped branch to be deleted")
+		}
+	}
+
+	expr := finishExpression(t, created["id"])
+	if expr.Status != "completed" || expr.Result != 10 {
+		t.Errorf("Expected completed with result 10, got %s with %v", expr.Status, expr.Result)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Delivery states of the callback of a finished expression
+const (
+	callbackPending   = "pending"
+	callbackDelivered = "delivered"
+	callbackFailed    = "failed"
+)
+
+// signatureHeader carries the hex encoded HMAC-SHA256 of the callback
+// body keyed with WEBHOOK_SECRET
+const signatureHeader = "X-Calc-Signature"
+
+// errCallbacksDisabled rejects a callback_url while WEBHOOK_SECRET is
+// not set, receivers could not tell our callbacks from forged ones
+var errCallbacksDisabled = errors.New("Callbacks require WEBHOOK_SECRET to be set")
+
+// max_callback_backoff caps the doubling delay between attempts
+const max_callback_backoff = time.Minute
+
+var (
+	webhook_secret       = os.Getenv("WEBHOOK_SECRET")
+	webhook_max_attempts = getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	webhook_retry_base   = time.Duration(getEnvAsInt("WEBHOOK_RETRY_MS", 1000)) * time.Millisecond
+	webhookClient        = &http.Client{Timeout: 10 * time.Second}
+)
+
+// CallbackAttempt records one POST of a callback, StatusCode is zero
+// when no response was received
+type CallbackAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func validCallbackURL(raw string) bool {
+	if raw == "" {
+		return true
+	}
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// notifyCallback starts delivering the final state of an expression to
+// its callback URL. The delivery starts once, whatever transitions come
+// later. It expects mutex to be held.
+func notifyCallback(expr *Expression) {
+	if expr.CallbackURL == "" || expr.CallbackStatus != "" {
+		return
+	}
+	startCallback(expr)
+}
+
+// resumeCallback restarts a delivery interrupted by a restart. It
+// expects mutex to be held.
+func resumeCallback(expr *Expression) {
+	if expr.CallbackURL == "" || expr.CallbackStatus == callbackDelivered || expr.CallbackStatus == callbackFailed {
+		return
+	}
+	startCallback(expr)
+}
+
+func startCallback(expr *Expression) {
+	// Callbacks stored before the secret was removed are not sent
+	// unsigned
+	if webhook_secret == "" {
+		log.Printf("Callback of expression %s dropped, WEBHOOK_SECRET is not set", expr.ID)
+		expr.CallbackStatus = callbackFailed
+		saveExpression(expr)
+		return
+	}
+	expr.CallbackStatus = callbackPending
+
+	view := expressionView(expr)
+	delete(view, "callback")
+	body, err := json.Marshal(map[string]interface{}{"expression": view})
+	if err != nil {
+		log.Println("Error encoding callback:", err)
+		return
+	}
+	go deliverCallback(expr.ID, expr.CallbackURL, body, len(expr.CallbackAttempts))
+}
+
+// deliverCallback posts body until the receiver answers with 2xx or the
+// attempts run out, waiting twice as long after every failure. Each
+// attempt is recorded on the expression.
+func deliverCallback(id string, callbackURL string, body []byte, made int) {
+	backoff := webhook_retry_base
+	for attempt := made + 1; ; attempt++ {
+		record := postCallback(callbackURL, body)
+		delivered := record.StatusCode >= 200 && record.StatusCode < 300
+		last := attempt >= webhook_max_attempts
+
+		mutex.Lock()
+		expr, exists := expressions[id]
+		if exists {
+			expr.CallbackAttempts = append(expr.CallbackAttempts, record)
+			switch {
+			case delivered:
+				expr.CallbackStatus = callbackDelivered
+			case last:
+				expr.CallbackStatus = callbackFailed
+			}
+			saveExpression(expr)
+		}
+		mutex.Unlock()
+
+		if !exists || delivered || last {
+			if exists && !delivered {
+				log.Printf("Callback of expression %s failed after %d attempts", id, attempt)
+			}
+			return
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, max_callback_backoff)
+	}
+}
+
+func postCallback(callbackURL string, body []byte) CallbackAttempt {
+	record := CallbackAttempt{Time: time.Now()}
+
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, "sha256="+signCallback(body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	resp.Body.Close()
+
+	record.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		record.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return record
+}
+
+func signCallback(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhook_secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// callbackReceiver answers callbacks with the given status codes in
+// turn, repeating the last one, and records the requests
+type callbackReceiver struct {
+	statuses []int
+
+	mutex    sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (c *callbackReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.requests = append(c.requests, r)
+	c.bodies = append(c.bodies, body)
+	w.WriteHeader(c.statuses[min(len(c.requests), len(c.statuses))-1])
+}
+
+func setupCallbacks(t *testing.T, maxAttempts int) {
+	secret, attempts, retry := webhook_secret, webhook_max_attempts, webhook_retry_base
+	webhook_secret, webhook_max_attempts, webhook_retry_base = "test-secret", maxAttempts, time.Millisecond
+	t.Cleanup(func() {
+		webhook_secret, webhook_max_attempts, webhook_retry_base = secret, attempts, retry
+	})
+}
+
+// waitForCallback waits until the callback of an expression is settled
+func waitForCallback(t *testing.T, id string) *Expression {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mutex.Lock()
+		expr := expressions[id]
+		status := expr.CallbackStatus
+		mutex.Unlock()
+		if status == callbackDelivered || status == callbackFailed {
+			return expr
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Callback was not settled in time")
+	return nil
+}
+
+func TestCallbackDelivery(t *testing.T) {
+	setupTest()
+	setupCallbacks(t, 5)
+	receiver := &callbackReceiver{statuses: []int{http.StatusInternalServerError, http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	expr := evaluateRequest(t, map[string]interface{}{
+		"expression":   "2 + 3 * 4",
+		"callback_url": server.URL + "/hook",
+	})
+	expr = waitForCallback(t, expr.ID)
+
+	if expr.CallbackStatus != callbackDelivered {
+		t.Fatalf("Expected delivered callback, got %s", expr.CallbackStatus)
+	}
+	if len(expr.CallbackAttempts) != 2 || expr.CallbackAttempts[0].StatusCode != http.StatusInternalServerError ||
+		expr.CallbackAttempts[1].StatusCode != http.StatusOK {
+		t.Errorf("Expected a failed and a delivered attempt, got %+v", expr.CallbackAttempts)
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	for idx, req := range receiver.requests {
+		if req.URL.Path != "/hook" || req.Method != http.MethodPost {
+			t.Errorf("Unexpected callback request %s %s", req.Method, req.URL.Path)
+		}
+		if signature := req.Header.Get(signatureHeader); signature != "sha256="+signCallback(receiver.bodies[idx]) {
+			t.Errorf("Unexpected signature %q", signature)
+		}
+	}
+
+	var payload struct {
+		Expression map[string]interface{}
+	}
+	if err := json.Unmarshal(receiver.bodies[1], &payload); err != nil {
+		t.Fatalf("Failed to decode callback body: %v", err)
+	}
+	if payload.Expression["id"] != expr.ID || payload.Expression["status"] != "completed" || payload.Expression["result"] != float64(14) {
+		t.Errorf("Unexpected callback body %v", payload.Expression)
+	}
+}
+
+func TestCallbackGivesUp(t *testing.T) {
+	setupTest()
+	setupCallbacks(t, 3)
+	receiver := &callbackReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	// Cancellation is a final status too
+	rr := httptest.NewRecorder()
+	body := `{"expression": "1 + 2", "callback_url": "` + server.URL + `"}`
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", body))
+	var created map[string]string
+	json.NewDecoder(rr.Body).Decode(&created)
+	handleExpressionByID(httptest.NewRecorder(), createTestRequest("DELETE", "/api/v1/expressions/"+created["id"], ""))
+
+	expr := waitForCallback(t, created["id"])
+	if expr.CallbackStatus != callbackFailed || len(expr.CallbackAttempts) != 3 {
+		t.Errorf("Expected the callback to fail after 3 attempts, got %s %+v", expr.CallbackStatus, expr.CallbackAttempts)
+	}
+	if expr.CallbackAttempts[2].Error == "" {
+		t.Error("Expected the failed attempt to record an error")
+	}
+}
+
+func TestCallbackDeliveredOnce(t *testing.T) {
+	setupTest()
+	setupCallbacks(t, 1)
+	receiver := &callbackReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	expr, err := calculate(generateID(), CalculateRequest{Expression: "2 + 3", CallbackURL: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	mutex.Lock()
+	cancelExpression(expr)
+	// A late transition must not start another delivery
+	expr.Status = "error"
+	expressionFinished(expr)
+	mutex.Unlock()
+
+	waitForCallback(t, expr.ID)
+	// Let a stray delivery arrive if there is one
+	time.Sleep(20 * time.Millisecond)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	if len(receiver.bodies) != 1 || !strings.Contains(string(receiver.bodies[0]), `"status":"cancelled"`) {
+		t.Errorf("Expected a single cancelled callback, got %d: %s", len(receiver.bodies), receiver.bodies)
+	}
+}
+
+func TestCallbackURLValidation(t *testing.T) {
+	setupTest()
+
+	// Without a secret callbacks could be forged
+	rr := httptest.NewRecorder()
+	handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", `{"expression": "1 + 2", "callback_url": "http://example.com/hook"}`))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "WEBHOOK_SECRET") {
+		t.Errorf("Expected callbacks to be rejected without a secret, got %v %s", rr.Code, rr.Body.String())
+	}
+
+	setupCallbacks(t, 1)
+	for _, callbackURL := range []string{"ftp://example.com", "not a url", "/relative"} {
+		rr := httptest.NewRecorder()
+		body, _ := json.Marshal(map[string]string{"expression": "1 + 2", "callback_url": callbackURL})
+		handleCalculate(rr, createTestRequest("POST", "/api/v1/calculate", string(body)))
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("callback_url %q: got %v, want %v", callbackURL, rr.Code, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestCallbackResumedAfterRestart(t *testing.T) {
+	setupTest()
+	setupCallbacks(t, 3)
+	receiver := &callbackReceiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	store = setupBoltStorage(t)
+	defer func() { store = memoryStorage{} }()
+
+	store.SaveExpression(&Expression{
+		ID:               "done",
+		Status:           "completed",
+		Result:           5,
+		CallbackURL:      server.URL,
+		CallbackStatus:   callbackPending,
+		CallbackAttempts: []CallbackAttempt{{Time: time.Now(), Error: "connection refused"}},
+	})
+
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+	expr := waitForCallback(t, "done")
+	if expr.CallbackStatus != callbackDelivered || len(expr.CallbackAttempts) != 2 {
+		t.Errorf("Expected the callback to be delivered on the second attempt, got %s %+v", expr.CallbackStatus, expr.CallbackAttempts)
+	}
+
+	stored, _ := store.LoadExpressions()
+	if len(stored) != 1 || stored[0].CallbackStatus != callbackDelivered {
+		t.Errorf("Expected the delivery to be stored, got %+v", stored)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket API multiplexes calculations over one connection.
+// Clients send calculate messages tagged with their own request_id, the
+// server answers with created or error and pushes the events of every
+// created expression up to its status event. Server messages carry the
+// request_id of the calculation they belong to, the messages of one
+// calculation come in order.
+
+const (
+	wsCalculate = "calculate"
+	wsError     = "error"
+)
+
+var upgrader = websocket.Upgrader{}
+
+type wsRequest struct {
+	Type      string `json:"type"`
+	RequestID string `json:"request_id"`
+	CalculateRequest
+}
+
+type wsMessage struct {
+	Type      string      `json:"type"`
+	RequestID string      `json:"request_id,omitempty"`
+	Data      interface{} `json:"data"`
+}
+
+// wsSession is the state of one connection
+type wsSession struct {
+	conn    *websocket.Conn
+	sub     *subscription
+	replies chan wsMessage
+	done    chan struct{}
+
+	mutex sync.Mutex
+	// requestIDs maps the watched expressions to the requests that
+	// created them
+	requestIDs map[string]string
+}
+
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has replied already
+		return
+	}
+	defer conn.Close()
+
+	session := &wsSession{
+		conn:       conn,
+		sub:        events.subscribe(),
+		replies:    make(chan wsMessage, max_buffered_events),
+		done:       make(chan struct{}),
+		requestIDs: make(map[string]string),
+	}
+	defer events.unsubscribe(session.sub)
+
+	writeDone := make(chan struct{})
+	go func() {
+		defer close(writeDone)
+		if err := session.write(); err != nil {
+			log.Println("Error writing to WebSocket:", err)
+		}
+		// Unblocks the read loop
+		conn.Close()
+	}()
+
+	session.read()
+	close(session.done)
+	<-writeDone
+}
+
+// read handles client messages until the connection is closed
+func (s *wsSession) read() {
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.reply(wsMessage{Type: wsError, Data: requestErrorBody(errInvalidRequest)})
+			continue
+		}
+		if req.Type != wsCalculate {
+			s.reply(wsMessage{Type: wsError, RequestID: req.RequestID, Data: map[string]string{"error": "Unknown message type"}})
+			continue
+		}
+		s.calculate(req)
+	}
+}
+
+func (s *wsSession) calculate(req wsRequest) {
+	// Watching before the expression exists, its created event is the
+	// first one the client gets
+	id := generateID()
+	s.mutex.Lock()
+	s.requestIDs[id] = req.RequestID
+	s.mutex.Unlock()
+	events.watch(s.sub, id)
+
+	if _, err := calculate(id, req.CalculateRequest); err != nil {
+		s.forget(id)
+		s.reply(wsMessage{Type: wsError, RequestID: req.RequestID, Data: requestErrorBody(err)})
+	}
+}
+
+func (s *wsSession) forget(id string) {
+	events.unwatch(s.sub, id)
+	s.mutex.Lock()
+	delete(s.requestIDs, id)
+	s.mutex.Unlock()
+}
+
+func (s *wsSession) reply(msg wsMessage) {
+	select {
+	case s.replies <- msg:
+	case <-s.done:
+	}
+}
+
+// write is the only writer of the connection, it sends replies, events
+// and keepalive pings
+func (s *wsSession) write() error {
+	keepalive := time.NewTicker(event_keepalive_interval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case <-keepalive.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return err
+			}
+		case msg := <-s.replies:
+			if err := s.conn.WriteJSON(msg); err != nil {
+				return err
+			}
+		case e, ok := <-s.sub.events:
+			if !ok {
+				// Too slow to keep up with the events
+				return nil
+			}
+			s.mutex.Lock()
+			requestID := s.requestIDs[e.ExpressionID]
+			s.mutex.Unlock()
+			if e.Name == eventStatus {
+				s.forget(e.ExpressionID)
+			}
+			if err := s.conn.WriteJSON(wsMessage{Type: e.Name, RequestID: requestID, Data: e.Data}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWebSocket(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+type testMessage struct {
+	Type      string                 `json:"type"`
+	RequestID string                 `json:"request_id"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) testMessage {
+	t.Helper()
+
+	var msg testMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	return msg
+}
+
+func TestWebSocketCalculate(t *testing.T) {
+	setupTest()
+	conn := dialWebSocket(t)
+
+	conn.WriteJSON(map[string]interface{}{"type": "calculate", "request_id": "r1", "expression": "2 + 3 * 4"})
+	created := readMessage(t, conn)
+	if created.Type != eventCreated || created.RequestID != "r1" {
+		t.Fatalf("Expected created reply to r1, got %+v", created)
+	}
+	id := created.Data["id"].(string)
+
+	finishExpression(t, id)
+
+	var names []string
+	for {
+		msg := readMessage(t, conn)
+		if msg.RequestID != "r1" {
+			t.Errorf("Expected request_id r1, got %+v", msg)
+		}
+		names = append(names, msg.Type)
+		if msg.Type == eventStatus {
+			if msg.Data["status"] != "completed" || msg.Data["result"] != float64(14) {
+				t.Errorf("Expected completed status with result 14, got %v", msg.Data)
+			}
+			break
+		}
+	}
+	expected := "task_dispatched task_completed task_dispatched task_completed status"
+	if strings.Join(names, " ") != expected {
+		t.Errorf("Got messages %v, want %s", names, expected)
+	}
+}
+
+func TestWebSocketMultiplexing(t *testing.T) {
+	setupTest()
+	conn := dialWebSocket(t)
+
+	conn.WriteJSON(map[string]interface{}{"type": "calculate", "request_id": "bad", "expression": "2 +"})
+	conn.WriteJSON(map[string]interface{}{"type": "calculate", "request_id": "literal", "expression": "-7"})
+	conn.WriteJSON(map[string]interface{}{"type": "subscribe", "request_id": "unknown"})
+	conn.WriteMessage(websocket.TextMessage, []byte("not json"))
+
+	// Replies to different requests may come in any order
+	byRequest := map[string][]testMessage{}
+	for range 5 {
+		msg := readMessage(t, conn)
+		byRequest[msg.RequestID] = append(byRequest[msg.RequestID], msg)
+	}
+
+	if msgs := byRequest["bad"]; len(msgs) != 1 || msgs[0].Type != wsError || msgs[0].Data["kind"] != ErrUnexpectedEnd {
+		t.Errorf("Expected syntax error of bad, got %+v", msgs)
+	}
+	if msgs := byRequest["literal"]; len(msgs) != 2 || msgs[0].Type != eventCreated ||
+		msgs[1].Type != eventStatus || msgs[1].Data["result"] != float64(-7) {
+		t.Errorf("Expected created and completed status of literal, got %+v", msgs)
+	}
+	if msgs := byRequest["unknown"]; len(msgs) != 1 || msgs[0].Type != wsError {
+		t.Errorf("Expected error for unknown message type, got %+v", msgs)
+	}
+	if msgs := byRequest[""]; len(msgs) != 1 || msgs[0].Data["error"] != errInvalidRequest.Error() {
+		t.Errorf("Expected error for invalid JSON, got %+v", msgs)
+	}
+}
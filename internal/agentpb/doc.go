@@ -0,0 +1,5 @@
+// Package agentpb holds the gRPC protocol between agents and the
+// orchestrator.
+package agentpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative agent.proto
@@ -0,0 +1,95 @@
+// Package ast holds the syntax tree of calculator expressions. The
+// orchestrator parses expressions into it and lowers it to tasks.
+package ast
+
+import (
+	"encoding/json"
+)
+
+// Node is an expression node. Position is a zero-based character offset
+// of the node's token in the source expression: the literal, the name or
+// the operator.
+type Node interface {
+	Pos() int
+}
+
+type Number struct {
+	// Value is the literal in plain decimal form, e.g. "255" for 0xFF
+	Value    string
+	Position int
+}
+
+type Variable struct {
+	Name     string
+	Position int
+}
+
+// Unary is a prefix operator: "-" or "!"
+type Unary struct {
+	Operator string
+	Operand  Node
+	Position int
+}
+
+type Binary struct {
+	Operator string
+	Left     Node
+	Right    Node
+	Position int
+}
+
+type Call struct {
+	Function  string
+	Arguments []Node
+	Position  int
+}
+
+func (n *Number) Pos() int   { return n.Position }
+func (n *Variable) Pos() int { return n.Position }
+func (n *Unary) Pos() int    { return n.Position }
+func (n *Binary) Pos() int   { return n.Position }
+func (n *Call) Pos() int     { return n.Position }
+
+func (n *Number) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":     "number",
+		"value":    n.Value,
+		"position": n.Position,
+	})
+}
+
+func (n *Variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":     "variable",
+		"name":     n.Name,
+		"position": n.Position,
+	})
+}
+
+func (n *Unary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":     "unary",
+		"operator": n.Operator,
+		"operand":  n.Operand,
+		"position": n.Position,
+	})
+}
+
+func (n *Binary) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":     "binary",
+		"operator": n.Operator,
+		"left":     n.Left,
+		"right":    n.Right,
+		"position": n.Position,
+	})
+}
+
+func (n *Call) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":      "call",
+		"function":  n.Function,
+		"arguments": n.Arguments,
+		"position":  n.Position,
+	})
+}